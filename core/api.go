@@ -6,8 +6,10 @@ import (
 	"encoding/json"
 	"fmt"
 	simplejson "github.com/bitly/go-simplejson"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"path"
 	"strconv"
 	"strings"
@@ -18,6 +20,10 @@ const (
 	DefaultAPIVersion = 1
 )
 
+// Version of the client, set at build time:
+// go build -ldflags "-X mayday/core.Version=1.0.0"
+var Version = "dev"
+
 type APIClient interface {
 	GetFormattedURL(prefix ...string) string
 	NewRequest(method string, url string, params []byte,
@@ -26,17 +32,56 @@ type APIClient interface {
 	Create(description string, private bool, config *Config) (*CaseResponse, error)
 	Pull(fileId string) (*UploadFile, error)
 	Upload(filename string) error
+	AddSignature(envelope string, signature string) error
+	WithCase(id string) APIClient
+	CreateToken(request TokenRequest) (*TokenResponse, error)
+	Tokens() ([]TokenInfo, error)
+	RevokeToken(tokenId string) error
+	List(query CaseQuery) (*CaseList, error)
+	SetStatus(status string, reason string) error
+	History() ([]CaseTransition, error)
+	AddComment(request CommentRequest) (*Comment, error)
+	Comments(fileId string) ([]Comment, error)
+	Delete() error
+	DeleteFile(fileId string) error
+	SetHold(hold bool) error
+	Entries(fileId string) ([]Entry, error)
+	Entry(fileId string, entryPath string) (io.ReadCloser, error)
+	Search(query SearchQuery) (*SearchResponse, error)
+	Audit(query AuditQuery) (*AuditResponse, error)
+	VerifyAudit() (*AuditVerification, error)
 }
 
 type DefaultAPIClient struct {
 	Server    string
 	Id        string
 	AuthToken string
+	Hostname  string
 	Client    *http.Client
 }
 
+const (
+	StatusRequestEntityTooLarge = 413
+	StatusInsufficientStorage   = 507
+)
+
+// APIError is an unexpected server response, with the error message the
+// server sent along.
+type APIError struct {
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("Invalid server response: %s", e.Status)
+	}
+	return fmt.Sprintf("Invalid server response: %s: %s", e.Status, e.Message)
+}
+
 type CaseResponse struct {
-	Id          int
+	Id          string `json:",omitempty"`
 	Description string
 	Created     string `json:",omitempty"`
 	IsPrivate   bool
@@ -51,7 +96,7 @@ func NewCaseResponse(json *simplejson.Json) (*CaseResponse, error) {
 		return nil, err
 	}
 
-	id, err := json.Get("Id").Int()
+	id, err := json.Get("Id").String()
 	if err != nil {
 		return nil, err
 	}
@@ -74,15 +119,257 @@ func NewCaseResponse(json *simplejson.Json) (*CaseResponse, error) {
 	}, nil
 }
 
+type SignatureRequest struct {
+	Envelope  string
+	Signature string
+}
+
+type TokenRequest struct {
+	Scopes      []string
+	ExpiresIn   string
+	MaxUses     int
+	Description string
+}
+
+type TokenInfo struct {
+	Id          int
+	Scopes      string
+	Description string
+	Expires     string
+	MaxUses     int
+	Uses        int
+	Revoked     bool
+	Created     string
+}
+
+type TokenResponse struct {
+	Token  TokenInfo
+	Secret string
+}
+
+// CaseQuery filters a case listing, empty fields are ignored.
+type CaseQuery struct {
+	Owner         string
+	Status        string
+	CreatedAfter  string
+	CreatedBefore string
+	Text          string
+	Signed        string
+	Private       string
+	Sort          string
+	Cursor        string
+	Limit         int
+}
+
+func (q CaseQuery) Values() url.Values {
+	values := url.Values{}
+
+	params := map[string]string{
+		"owner":          q.Owner,
+		"status":         q.Status,
+		"created_after":  q.CreatedAfter,
+		"created_before": q.CreatedBefore,
+		"q":              q.Text,
+		"signed":         q.Signed,
+		"private":        q.Private,
+		"sort":           q.Sort,
+		"cursor":         q.Cursor,
+	}
+
+	for name, value := range params {
+		if value != "" {
+			values.Set(name, value)
+		}
+	}
+
+	if q.Limit > 0 {
+		values.Set("limit", strconv.Itoa(q.Limit))
+	}
+
+	return values
+}
+
+type CaseSummary struct {
+	Id          string
+	Description string
+	Created     string
+	Updated     string
+	IsSigned    bool
+	IsPrivate   bool
+	Status      string
+	Owner       string
+	Team        string
+}
+
+type CaseList struct {
+	Cases []CaseSummary
+	Next  string
+}
+
+type StatusRequest struct {
+	Status string
+	Reason string
+}
+
+type CaseTransition struct {
+	Id         int
+	FromStatus string
+	ToStatus   string
+	Actor      string
+	Reason     string
+	Created    string
+}
+
+type HoldRequest struct {
+	Hold bool
+}
+
+type CommentRequest struct {
+	Body    string
+	FileId  int
+	Path    string
+	Private bool `json:",omitempty"`
+}
+
+type Comment struct {
+	Id      int
+	Author  string
+	Body    string
+	FileId  int
+	Path    string
+	Private bool
+	Created string
+}
+
 type UploadFile struct {
 	Filename string
 	Content  string
+	Hostname string `json:",omitempty"`
+	Version  string `json:",omitempty"`
+}
+
+// Entry is a member of an uploaded report archive.
+type Entry struct {
+	Id   int
+	Path string
+	Size int64
+	Type string
+}
+
+type SearchQuery struct {
+	Text  string
+	Regex bool
+	Words bool
+	Case  string
+	Limit int
+}
+
+type SearchMatch struct {
+	Case   string
+	FileId int
+	File   string
+	Entry  string
+	Line   int
+	Text   string
+}
+
+type SearchResponse struct {
+	Matches   []SearchMatch
+	Truncated bool
+	Indexing  int `json:",omitempty"`
+}
+
+type AuditQuery struct {
+	Case   string
+	Actor  string
+	Action string
+	Since  string
+	Until  string
+	Before int
+	Limit  int
+}
+
+func (q AuditQuery) Values() url.Values {
+	values := url.Values{}
+
+	params := map[string]string{
+		"case":   q.Case,
+		"actor":  q.Actor,
+		"action": q.Action,
+		"since":  q.Since,
+		"until":  q.Until,
+	}
+
+	for name, value := range params {
+		if value != "" {
+			values.Set(name, value)
+		}
+	}
+
+	if q.Before > 0 {
+		values.Set("before", strconv.Itoa(q.Before))
+	}
+	if q.Limit > 0 {
+		values.Set("limit", strconv.Itoa(q.Limit))
+	}
+
+	return values
+}
+
+type AuditEvent struct {
+	Id         int
+	Action     string
+	Actor      string
+	RemoteAddr string
+	Case       string
+	FileId     int
+	Detail     string
+	Created    string
+	PrevHash   string
+	Hash       string
+}
+
+type AuditResponse struct {
+	Events []AuditEvent
+	Next   int
+}
+
+type AuditVerification struct {
+	Valid    bool
+	Events   int
+	Head     string
+	BrokenAt int
+	Error    string
+}
+
+// FileInfo describes a report stored on the server.
+type FileInfo struct {
+	Id          int
+	Path        string
+	Size        int64
+	Digest      string
+	ContentType string
+	Uploader    string
+	Hostname    string
+	Version     string
+	Created     string
 }
 
 type ConfigResponse struct {
-	Signed string
-	Config string
-	Files  []string
+	Signed   string
+	Config   string
+	Envelope string
+	Status   string
+	Files    []FileInfo
+	Usage    *Usage
+}
+
+type Usage struct {
+	CaseBytes     int64
+	CaseQuota     int64
+	OwnerBytes    int64
+	OwnerQuota    int64
+	MaxUploadSize int64
 }
 
 func NewConfigResponse(j *simplejson.Json) (*ConfigResponse, error) {
@@ -98,18 +385,21 @@ func NewConfigResponse(j *simplejson.Json) (*ConfigResponse, error) {
 		return nil, err
 	}
 
-	files, err := j.Get("Files").Array()
-	if err != nil {
+	if err := decodeJSON(j.Get("Files"), &c.Files); err != nil {
 		return nil, err
 	}
 
-	for _, file := range files {
-		q := file.(map[string]interface{})
-		c.Files = append(c.Files, q["Id"].(json.Number).String())
-	}
-
 	c.Config = config
 	c.Signed = signed
+	c.Envelope = j.Get("Envelope").MustString()
+	c.Status = j.Get("Status").MustString()
+
+	if usage, ok := j.CheckGet("Usage"); ok {
+		c.Usage = new(Usage)
+		if err := decodeJSON(usage, c.Usage); err != nil {
+			return nil, err
+		}
+	}
 
 	return &c, nil
 }
@@ -129,19 +419,23 @@ func (api DefaultAPIClient) GetFormattedURL(prefix ...string) string {
 		strings.Join(prefix, "/"))
 }
 
-func (api DefaultAPIClient) NewRequest(method string, url string,
-	params []byte, validStatus []int) (*simplejson.Json, error) {
-
-	if api.AuthToken != "" {
-		url = fmt.Sprintf("%s?token=%s", url, api.AuthToken)
-	}
+// NewRawRequest sends a request and returns the response body unread, for
+// endpoints that do not answer JSON. The caller closes it.
+func (api DefaultAPIClient) NewRawRequest(method string, url string,
+	params []byte, validStatus []int) (io.ReadCloser, error) {
 
 	request, err := http.NewRequest(method, url, bytes.NewReader(params))
 	if err != nil {
 		return nil, err
 	}
 
-	if method == "POST" {
+	request.Header.Set("User-Agent", "mayday/"+Version)
+
+	if api.AuthToken != "" {
+		request.Header.Set("Authorization", "Bearer "+api.AuthToken)
+	}
+
+	if method == "POST" || method == "PUT" {
 		request.Header.Set("Content-Type", "application/json")
 	}
 
@@ -151,12 +445,39 @@ func (api DefaultAPIClient) NewRequest(method string, url string,
 	}
 
 	if !Contains(validStatus, response.StatusCode) {
-		return nil, fmt.Errorf("Invalid server response: %s", response.Status)
+		defer response.Body.Close()
+
+		message, _ := ioutil.ReadAll(io.LimitReader(response.Body, 4096))
+		return nil, &APIError{
+			StatusCode: response.StatusCode,
+			Status:     response.Status,
+			Message:    strings.TrimSpace(string(message)),
+		}
 	}
 
-	defer response.Body.Close()
+	return response.Body, nil
+}
+
+func (api DefaultAPIClient) NewRequest(method string, url string,
+	params []byte, validStatus []int) (*simplejson.Json, error) {
 
-	reader, err := simplejson.NewFromReader(response.Body)
+	content, err := api.NewRawRequest(method, url, params, validStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	defer content.Close()
+
+	body, err := ioutil.ReadAll(content)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		return simplejson.New(), nil
+	}
+
+	reader, err := simplejson.NewJson(body)
 	if err != nil {
 		return nil, err
 	}
@@ -164,6 +485,15 @@ func (api DefaultAPIClient) NewRequest(method string, url string,
 	return reader, nil
 }
 
+func decodeJSON(j *simplejson.Json, v interface{}) error {
+	encoded, err := j.MarshalJSON()
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(encoded, v)
+}
+
 func (api DefaultAPIClient) Config() (*ConfigResponse, error) {
 	response, err := api.NewRequest("GET", api.GetFormattedURL("case", api.Id), nil, []int{200})
 	if err != nil {
@@ -238,6 +568,8 @@ func (api DefaultAPIClient) Upload(filename string) error {
 	c, err := json.Marshal(UploadFile{
 		Filename: path.Base(filename),
 		Content:  encoded,
+		Hostname: api.Hostname,
+		Version:  Version,
 	})
 
 	if err != nil {
@@ -245,9 +577,258 @@ func (api DefaultAPIClient) Upload(filename string) error {
 	}
 
 	_, err = api.NewRequest("POST", api.GetFormattedURL("case", api.Id, "file"), c, []int{200, 201})
+	if apiErr, ok := err.(*APIError); ok {
+		switch apiErr.StatusCode {
+		case StatusRequestEntityTooLarge:
+			return fmt.Errorf("%s is too large for the server (%s); collect fewer or smaller files "+
+				"in the case configuration, or ask the server administrator to raise quota.max_upload_size",
+				path.Base(filename), apiErr.Message)
+		case StatusInsufficientStorage:
+			return fmt.Errorf("no storage left for this report (%s); delete older reports with "+
+				"'mayday case delete --file-id', or ask the server administrator to raise the quota",
+				apiErr.Message)
+		}
+	}
+
+	return err
+}
+
+func (api DefaultAPIClient) AddSignature(envelope string, signature string) error {
+	c, err := json.Marshal(SignatureRequest{Envelope: envelope, Signature: signature})
+	if err != nil {
+		return err
+	}
+
+	_, err = api.NewRequest("POST", api.GetFormattedURL("case", api.Id, "signature"), c, []int{200, 201})
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+func (api DefaultAPIClient) WithCase(id string) APIClient {
+	api.Id = id
+	return api
+}
+
+func (api DefaultAPIClient) CreateToken(request TokenRequest) (*TokenResponse, error) {
+	c, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := api.NewRequest("POST", api.GetFormattedURL("case", api.Id, "token"), c, []int{201})
+	if err != nil {
+		return nil, err
+	}
+
+	token := new(TokenResponse)
+	if err := decodeJSON(response, token); err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func (api DefaultAPIClient) Tokens() ([]TokenInfo, error) {
+	response, err := api.NewRequest("GET", api.GetFormattedURL("case", api.Id, "token"), nil, []int{200})
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens []TokenInfo
+	if err := decodeJSON(response, &tokens); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func (api DefaultAPIClient) RevokeToken(tokenId string) error {
+	_, err := api.NewRequest("DELETE", api.GetFormattedURL("case", api.Id, "token", tokenId), nil, []int{200, 204})
+	return err
+}
+
+func (api DefaultAPIClient) List(query CaseQuery) (*CaseList, error) {
+	address := api.GetFormattedURL("case")
+	if values := query.Values(); len(values) > 0 {
+		address += "?" + values.Encode()
+	}
+
+	response, err := api.NewRequest("GET", address, nil, []int{200})
+	if err != nil {
+		return nil, err
+	}
+
+	list := new(CaseList)
+	if err := decodeJSON(response, list); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+func (api DefaultAPIClient) SetStatus(status string, reason string) error {
+	c, err := json.Marshal(StatusRequest{Status: status, Reason: reason})
+	if err != nil {
+		return err
+	}
+
+	_, err = api.NewRequest("POST", api.GetFormattedURL("case", api.Id, "status"), c, []int{200})
+	return err
+}
+
+func (api DefaultAPIClient) History() ([]CaseTransition, error) {
+	response, err := api.NewRequest("GET", api.GetFormattedURL("case", api.Id, "history"), nil, []int{200})
+	if err != nil {
+		return nil, err
+	}
+
+	var transitions []CaseTransition
+	if err := decodeJSON(response, &transitions); err != nil {
+		return nil, err
+	}
+
+	return transitions, nil
+}
+
+func (api DefaultAPIClient) AddComment(request CommentRequest) (*Comment, error) {
+	c, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := api.NewRequest("POST", api.GetFormattedURL("case", api.Id, "comments"), c, []int{201})
+	if err != nil {
+		return nil, err
+	}
+
+	comment := new(Comment)
+	if err := decodeJSON(response, comment); err != nil {
+		return nil, err
+	}
+
+	return comment, nil
+}
+
+func (api DefaultAPIClient) Comments(fileId string) ([]Comment, error) {
+	address := api.GetFormattedURL("case", api.Id, "comments")
+	if fileId != "" {
+		address += "?" + url.Values{"file-id": {fileId}}.Encode()
+	}
+
+	response, err := api.NewRequest("GET", address, nil, []int{200})
+	if err != nil {
+		return nil, err
+	}
+
+	var comments []Comment
+	if err := decodeJSON(response, &comments); err != nil {
+		return nil, err
+	}
+
+	return comments, nil
+}
+
+func (api DefaultAPIClient) Delete() error {
+	_, err := api.NewRequest("DELETE", api.GetFormattedURL("case", api.Id), nil, []int{200, 204})
+	return err
+}
+
+func (api DefaultAPIClient) DeleteFile(fileId string) error {
+	_, err := api.NewRequest("DELETE", api.GetFormattedURL("case", api.Id, "file", fileId), nil, []int{200, 204})
+	return err
+}
+
+func (api DefaultAPIClient) SetHold(hold bool) error {
+	c, err := json.Marshal(HoldRequest{Hold: hold})
+	if err != nil {
+		return err
+	}
+
+	_, err = api.NewRequest("PUT", api.GetFormattedURL("case", api.Id, "hold"), c, []int{200})
+	return err
+}
+
+func (api DefaultAPIClient) Entries(fileId string) ([]Entry, error) {
+	response, err := api.NewRequest("GET", api.GetFormattedURL("case", api.Id, "file", fileId, "entries"), nil, []int{200})
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	if err := decodeJSON(response, &entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (api DefaultAPIClient) Entry(fileId string, entryPath string) (io.ReadCloser, error) {
+	address := api.GetFormattedURL("case", api.Id, "file", fileId, "entry") + "?" +
+		url.Values{"path": {entryPath}}.Encode()
+
+	return api.NewRawRequest("GET", address, nil, []int{200})
+}
+
+func (api DefaultAPIClient) Search(query SearchQuery) (*SearchResponse, error) {
+	values := url.Values{"q": {query.Text}}
+	if query.Regex {
+		values.Set("regex", "true")
+	}
+	if query.Words {
+		values.Set("words", "true")
+	}
+	if query.Case != "" {
+		values.Set("case", query.Case)
+	}
+	if query.Limit > 0 {
+		values.Set("limit", strconv.Itoa(query.Limit))
+	}
+
+	response, err := api.NewRequest("GET", api.GetFormattedURL("search")+"?"+values.Encode(), nil, []int{200})
+	if err != nil {
+		return nil, err
+	}
+
+	result := new(SearchResponse)
+	if err := decodeJSON(response, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (api DefaultAPIClient) Audit(query AuditQuery) (*AuditResponse, error) {
+	address := api.GetFormattedURL("audit")
+	if values := query.Values(); len(values) > 0 {
+		address += "?" + values.Encode()
+	}
+
+	response, err := api.NewRequest("GET", address, nil, []int{200})
+	if err != nil {
+		return nil, err
+	}
+
+	result := new(AuditResponse)
+	if err := decodeJSON(response, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (api DefaultAPIClient) VerifyAudit() (*AuditVerification, error) {
+	response, err := api.NewRequest("GET", api.GetFormattedURL("audit", "verify"), nil, []int{200})
+	if err != nil {
+		return nil, err
+	}
+
+	result := new(AuditVerification)
+	if err := decodeJSON(response, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
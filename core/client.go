@@ -1,6 +1,8 @@
 package core
 
 import (
+	"code.google.com/p/go.crypto/openpgp"
+	pgperrors "code.google.com/p/go.crypto/openpgp/errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -8,25 +10,41 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"strconv"
 	"sync"
+	"time"
 )
 
 type Client struct {
-	Hostname  string
-	APIClient APIClient
-	Env       Environment
+	Hostname   string
+	Server     string
+	Id         string
+	APIClient  APIClient
+	Env        Environment
+	KeyRing    string
+	KeyServer  string
+	WKDAddress string
+	Signer     Signer
+	Policy     *Policy
+	Validity   time.Duration
 }
 
 func NewClient(env Environment, server string, uuid string, authToken string) (*Client, error) {
+	hostname, _ := env.GetHostName()
+
 	api := &DefaultAPIClient{
 		Client:    &http.Client{},
 		Server:    server,
 		Id:        uuid,
 		AuthToken: authToken,
+		Hostname:  hostname,
 	}
 
 	return &Client{
+		Hostname:  hostname,
 		Env:       env,
+		Server:    server,
+		Id:        uuid,
 		APIClient: api,
 	}, nil
 }
@@ -42,8 +60,11 @@ func (client *Client) Create(configPath string, description string, private bool
 		return nil, err
 	}
 
+	// The envelope binds the case identifier, which the server assigns, so
+	// the signer is loaded first and the case removed if signing fails.
+	var signer Signer
 	if pgp {
-		err = config.Sign(keyid)
+		signer, err = client.signer()
 		if err != nil {
 			return nil, err
 		}
@@ -54,9 +75,86 @@ func (client *Client) Create(configPath string, description string, private bool
 		return "", fmt.Errorf("error creating new case on server: %s", err)
 	}
 
+	if pgp {
+		caseId := new_case.Id
+		api := client.APIClient.WithCase(caseId)
+
+		if err := client.signCase(api, signer, caseId, config, nil, keyid); err != nil {
+			if deleteErr := api.Delete(); deleteErr != nil {
+				return nil, fmt.Errorf("cannot sign case %s: %s; removing the unsigned case failed: %s",
+					caseId, err, deleteErr)
+			}
+			return nil, fmt.Errorf("cannot sign case, it was not created: %s", err)
+		}
+
+		new_case.Signed = config.Signed
+	}
+
 	return new_case, nil
 }
 
+func (client *Client) signer() (Signer, error) {
+	if client.Signer != nil {
+		return client.Signer, nil
+	}
+	return NewPGP(client.KeyRing)
+}
+
+// Sign adds a co-signature made with keyid to the signed envelope of the
+// case, creating the envelope when the case has not been signed yet.
+func (client *Client) Sign(keyid string) error {
+	apiConfig, err := client.APIClient.Config()
+	if err != nil {
+		return fmt.Errorf("Error getting configuration from server: %s", err)
+	}
+
+	config, err := NewConfig(apiConfig.Config)
+	if err != nil {
+		return err
+	}
+
+	var envelope *Envelope
+	if apiConfig.Envelope != "" {
+		envelope, err = ParseEnvelope(apiConfig.Envelope)
+		if err != nil {
+			return err
+		}
+
+		err = envelope.Check(client.Id, client.Server, config, client.Env.GetCurrentTime())
+		if err != nil {
+			return fmt.Errorf("refusing to co-sign: %s", err)
+		}
+	}
+
+	signer, err := client.signer()
+	if err != nil {
+		return err
+	}
+
+	return client.signCase(client.APIClient, signer, client.Id, config, envelope, keyid)
+}
+
+func (client *Client) signCase(api APIClient, signer Signer, caseId string, config *Config, envelope *Envelope, keyid string) error {
+	var err error
+
+	if envelope == nil {
+		envelope, err = NewEnvelope(caseId, client.Server, config, client.Env.GetCurrentTime(), client.Validity)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := config.Sign(signer, envelope, keyid); err != nil {
+		return err
+	}
+
+	if err := api.AddSignature(envelope.Raw, config.Signed); err != nil {
+		return fmt.Errorf("error adding signature to case: %s", err)
+	}
+
+	return nil
+}
+
 func (client *Client) PullAll() (map[string]string, error) {
 	apiConfig, err := client.APIClient.Config()
 
@@ -67,7 +165,7 @@ func (client *Client) PullAll() (map[string]string, error) {
 	files := make(map[string]string, len(apiConfig.Files))
 
 	for _, f := range apiConfig.Files {
-		file, err := client.APIClient.Pull(f)
+		file, err := client.APIClient.Pull(strconv.Itoa(f.Id))
 		if err != nil {
 			return nil, err
 		}
@@ -88,8 +186,8 @@ func (client *Client) Pull(id string) (map[string]string, error) {
 	files := make(map[string]string, len(apiConfig.Files))
 
 	for _, f := range apiConfig.Files {
-		if f == id {
-			file, err := client.APIClient.Pull(f)
+		if strconv.Itoa(f.Id) == id {
+			file, err := client.APIClient.Pull(id)
 			if err != nil {
 				return nil, err
 			}
@@ -104,7 +202,7 @@ func (client *Client) Pull(id string) (map[string]string, error) {
 	return files, nil
 }
 
-func (client *Client) Show() (interface{}, error) {
+func (client *Client) Show() (*ConfigResponse, error) {
 	apiConfig, err := client.APIClient.Config()
 	if err != nil {
 		return nil, fmt.Errorf("Error getting configuration from server: %s", err)
@@ -112,6 +210,86 @@ func (client *Client) Show() (interface{}, error) {
 	return apiConfig, nil
 }
 
+// Verify checks every signature in signed against the trusted keyring (plus
+// an explicit --keyring), offering to trust signers whose key is unknown. It
+// returns the distinct keys that produced a valid signature; invalid ones are
+// logged and skipped, the policy decides whether enough remain.
+func (client *Client) Verify(config *Config, envelope *Envelope, signed string) ([]*openpgp.Entity, error) {
+	trust, err := NewTrustStore(client.Env)
+	if err != nil {
+		return nil, err
+	}
+
+	verifier := &PGP{KeyRingPath: trust.KeyRingPath}
+	if client.KeyRing != "" {
+		verifier.ExtraKeyRingPaths = append(verifier.ExtraKeyRingPaths, client.KeyRing)
+	}
+
+	signatures := SplitSignatures(signed)
+	if len(signatures) == 0 {
+		return nil, fmt.Errorf("case configuration is not signed")
+	}
+
+	var signers []*openpgp.Entity
+	seen := make(map[string]bool)
+
+	for _, signature := range signatures {
+		entity, err := config.Verify(verifier, envelope, signature)
+		if err == pgperrors.ErrUnknownIssuer {
+			if _, err := client.TrustSigner(trust, signature); err != nil {
+				log.Printf("Ignoring signature: %s", err)
+				continue
+			}
+
+			entity, err = config.Verify(verifier, envelope, signature)
+		}
+
+		if err != nil {
+			log.Printf("Ignoring signature: %s", err)
+			continue
+		}
+
+		if !seen[Fingerprint(entity)] {
+			seen[Fingerprint(entity)] = true
+			signers = append(signers, entity)
+		}
+	}
+
+	return signers, nil
+}
+
+func (client *Client) TrustSigner(trust *TrustStore, signature string) (*openpgp.Entity, error) {
+	keyid, err := SignatureIssuer(signature)
+	if err != nil {
+		return nil, err
+	}
+
+	entity, err := client.LookupKey(keyid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := trust.Import(entity); err != nil {
+		return nil, err
+	}
+
+	return entity, nil
+}
+
+func (client *Client) LookupKey(keyid string) (*openpgp.Entity, error) {
+	fetcher, err := NewKeyFetcher(client.KeyServer, client.WKDAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	userKeyRing, err := GetDefaultKeyRingPath()
+	if err != nil {
+		return nil, err
+	}
+
+	return LookupKey(fetcher, keyid, userKeyRing)
+}
+
 func (client *Client) Run(pgp bool, upload bool, timeout int, dryRun bool) error {
 	reportPath, err := client.Env.GetTempReportDirectory()
 
@@ -124,21 +302,60 @@ func (client *Client) Run(pgp bool, upload bool, timeout int, dryRun bool) error
 		return fmt.Errorf("Error getting configuration from server: %s", err)
 	}
 
+	if apiConfig.Status == "closed" || apiConfig.Status == "archived" {
+		return fmt.Errorf("case is %s and does not accept new reports", apiConfig.Status)
+	}
+
 	config, err := NewConfig(apiConfig.Config)
 	if err != nil {
 		return err
 	}
 
 	if pgp {
-		entity, err := config.Verify(apiConfig.Signed)
+		if apiConfig.Envelope == "" {
+			return fmt.Errorf("case configuration has no signed envelope")
+		}
+
+		envelope, err := ParseEnvelope(apiConfig.Envelope)
+		if err != nil {
+			return err
+		}
+
+		err = envelope.Check(client.Id, client.Server, config, client.Env.GetCurrentTime())
+		if err != nil {
+			return err
+		}
+
+		signers, err := client.Verify(config, envelope, apiConfig.Signed)
 		if err != nil {
 			return err
 		}
 
-		answer := ConfirmKey(entity, config)
+		policy := client.Policy
+		if policy == nil {
+			policy, err = LoadPolicy(client.Env)
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := policy.Enforce(config, signers); err != nil {
+			return err
+		}
+
+		answer := ConfirmKey(signers, config)
 		if answer != true {
 			return fmt.Errorf("PGP key has not been accepted")
 		}
+
+		trust, err := NewTrustStore(client.Env)
+		if err != nil {
+			return err
+		}
+
+		if err := trust.CheckPin(client.Server, signers); err != nil {
+			return err
+		}
 	}
 
 	wg := new(sync.WaitGroup)
@@ -171,6 +388,14 @@ func (client *Client) Run(pgp bool, upload bool, timeout int, dryRun bool) error
 		return err
 	}
 
+	if usage := apiConfig.Usage; usage != nil && usage.MaxUploadSize > 0 {
+		finfo, err := os.Stat(filename)
+		if err == nil && finfo.Size() > usage.MaxUploadSize {
+			return fmt.Errorf("report %s is %s, over the server upload limit of %s; collect fewer or smaller "+
+				"files in the case configuration", filename, FormatBytes(finfo.Size()), FormatBytes(usage.MaxUploadSize))
+		}
+	}
+
 	err = client.APIClient.Upload(filename)
 	if err != nil {
 		return err
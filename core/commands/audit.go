@@ -0,0 +1,131 @@
+package commands
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"mayday/core"
+	"os"
+)
+
+type AuditCommand struct {
+	fs     *flag.FlagSet
+	token  *string
+	server *string
+	id     *string
+	actor  *string
+	action *string
+	since  *string
+	until  *string
+	before *int
+	limit  *int
+	json   *bool
+}
+
+func (cmd *AuditCommand) Name() string {
+	return "audit"
+}
+
+func (cmd *AuditCommand) Description() string {
+	return "Read the server audit log, or check its integrity: audit [verify]"
+}
+
+func (cmd *AuditCommand) DefineFlags(fs *flag.FlagSet) {
+	cmd.fs = fs
+	cmd.token = fs.String("token", "", "API key, prefer $MAYDAY_TOKEN or ~/.mayday/credentials")
+	cmd.server = fs.String("server", core.DefaultAPIBaseURL, "Mayday server address")
+	cmd.id = fs.String("case", "", "Only events of this case")
+	cmd.actor = fs.String("actor", "", "Only events by this user")
+	cmd.action = fs.String("action", "", "Only events of this action, e.g. file.download")
+	cmd.since = fs.String("since", "", "Only events at or after this RFC 3339 time")
+	cmd.until = fs.String("until", "", "Only events before this RFC 3339 time")
+	cmd.before = fs.Int("before", 0, "Only events older than this event id")
+	cmd.limit = fs.Int("limit", 0, "Number of events (server default 100)")
+	cmd.json = fs.Bool("json", false, "Print the events as JSON")
+}
+
+func (cmd *AuditCommand) Run(env core.Environment) {
+	action := cmd.fs.Arg(0)
+	if action != "" {
+		cmd.fs.Parse(cmd.fs.Args()[1:])
+	}
+
+	mayday, err := newClient(env, *cmd.server, "", *cmd.token)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	switch action {
+	case "":
+		err = cmd.list(mayday)
+	case "verify":
+		err = cmd.verify(mayday)
+	default:
+		fmt.Println("Please specify no action to list events, or verify")
+		os.Exit(1)
+	}
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func (cmd *AuditCommand) list(mayday *core.Client) error {
+	result, err := mayday.APIClient.Audit(core.AuditQuery{
+		Case:   *cmd.id,
+		Actor:  *cmd.actor,
+		Action: *cmd.action,
+		Since:  *cmd.since,
+		Until:  *cmd.until,
+		Before: *cmd.before,
+		Limit:  *cmd.limit,
+	})
+	if err != nil {
+		return err
+	}
+
+	if *cmd.json {
+		encoded, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(string(encoded))
+		return nil
+	}
+
+	fmt.Printf("%-8s %-26s %-14s %-20s %-16s %-36s %s\n", "ID", "TIME", "ACTION", "ACTOR", "ADDRESS", "CASE", "DETAIL")
+	for _, event := range result.Events {
+		detail := event.Detail
+		if event.FileId != 0 {
+			detail = fmt.Sprintf("file %d %s", event.FileId, detail)
+		}
+
+		fmt.Printf("%-8d %-26s %-14s %-20s %-16s %-36s %s\n", event.Id, event.Created, event.Action, event.Actor,
+			event.RemoteAddr, event.Case, detail)
+	}
+
+	if result.Next != 0 {
+		fmt.Printf("\nMore events available, continue with --before %d\n", result.Next)
+	}
+
+	return nil
+}
+
+func (cmd *AuditCommand) verify(mayday *core.Client) error {
+	result, err := mayday.APIClient.VerifyAudit()
+	if err != nil {
+		return err
+	}
+
+	if !result.Valid {
+		fmt.Printf("Audit log is corrupted at event %d: %s\n", result.BrokenAt, result.Error)
+		fmt.Printf("%d events verified before it\n", result.Events)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Audit log is intact: %d events, head hash %s\n", result.Events, result.Head)
+	return nil
+}
@@ -0,0 +1,143 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"mayday/core"
+	"os"
+)
+
+type CaseCommand struct {
+	fs     *flag.FlagSet
+	id     *string
+	token  *string
+	server *string
+	reason *string
+	fileId *string
+	yes    *bool
+}
+
+func (cmd *CaseCommand) Name() string {
+	return "case"
+}
+
+func (cmd *CaseCommand) Description() string {
+	return "Manage the lifecycle of a case: case close|reopen|history|delete|hold|unhold"
+}
+
+func (cmd *CaseCommand) DefineFlags(fs *flag.FlagSet) {
+	cmd.fs = fs
+	cmd.id = fs.String("case", "", "Case ID")
+	cmd.token = fs.String("token", "", "API key, prefer $MAYDAY_TOKEN or ~/.mayday/credentials")
+	cmd.server = fs.String("server", core.DefaultAPIBaseURL, "Mayday server address")
+	cmd.reason = fs.String("reason", "", "Reason recorded in the case history")
+	cmd.fileId = fs.String("file-id", "", "Delete only this file of the case")
+	cmd.yes = fs.Bool("yes", false, "Do not ask for confirmation before deleting")
+}
+
+func (cmd *CaseCommand) Run(env core.Environment) {
+	action := cmd.fs.Arg(0)
+	if action != "" {
+		cmd.fs.Parse(cmd.fs.Args()[1:])
+	}
+
+	if *cmd.id == "" {
+		fmt.Println("Please specify a Case Id --case")
+		os.Exit(1)
+	}
+
+	mayday, err := newClient(env, *cmd.server, *cmd.id, *cmd.token)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	switch action {
+	case "close":
+		err = cmd.setStatus(mayday, "closed")
+	case "reopen":
+		err = cmd.setStatus(mayday, "open")
+	case "history":
+		err = cmd.history(mayday)
+	case "delete":
+		err = cmd.delete(mayday)
+	case "hold":
+		err = cmd.setHold(mayday, true)
+	case "unhold":
+		err = cmd.setHold(mayday, false)
+	default:
+		fmt.Println("Please specify one of: close, reopen, history, delete, hold, unhold")
+		os.Exit(1)
+	}
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func (cmd *CaseCommand) setStatus(mayday *core.Client, status string) error {
+	if err := mayday.APIClient.SetStatus(status, *cmd.reason); err != nil {
+		return err
+	}
+
+	fmt.Printf("Case %s is now %s\n", *cmd.id, status)
+	return nil
+}
+
+func (cmd *CaseCommand) history(mayday *core.Client) error {
+	transitions, err := mayday.APIClient.History()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%-26s %-16s %-16s %-16s %s\n", "DATE", "FROM", "TO", "BY", "REASON")
+	for _, t := range transitions {
+		fmt.Printf("%-26s %-16s %-16s %-16s %s\n", t.Created, t.FromStatus, t.ToStatus, t.Actor, t.Reason)
+	}
+
+	return nil
+}
+
+func (cmd *CaseCommand) delete(mayday *core.Client) error {
+	target := fmt.Sprintf("case %s and all its files", *cmd.id)
+	if *cmd.fileId != "" {
+		target = fmt.Sprintf("file %s of case %s", *cmd.fileId, *cmd.id)
+	}
+
+	if !*cmd.yes {
+		var answer string
+		fmt.Printf("Permanently delete %s (y/n)? ", target)
+		fmt.Scanf("%s", &answer)
+		if answer != "y" {
+			return fmt.Errorf("deletion cancelled")
+		}
+	}
+
+	var err error
+	if *cmd.fileId != "" {
+		err = mayday.APIClient.DeleteFile(*cmd.fileId)
+	} else {
+		err = mayday.APIClient.Delete()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Deleted %s\n", target)
+	return nil
+}
+
+func (cmd *CaseCommand) setHold(mayday *core.Client, hold bool) error {
+	if err := mayday.APIClient.SetHold(hold); err != nil {
+		return err
+	}
+
+	if hold {
+		fmt.Printf("Case %s is under legal hold\n", *cmd.id)
+	} else {
+		fmt.Printf("Legal hold lifted from case %s\n", *cmd.id)
+	}
+	return nil
+}
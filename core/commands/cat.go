@@ -0,0 +1,72 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"mayday/core"
+	"os"
+)
+
+type CatCommand struct {
+	fs     *flag.FlagSet
+	id     *string
+	token  *string
+	server *string
+	fileId *string
+}
+
+func (cmd *CatCommand) Name() string {
+	return "cat"
+}
+
+func (cmd *CatCommand) Description() string {
+	return "Print one entry of an uploaded report, or list the entries when no path is given."
+}
+
+func (cmd *CatCommand) DefineFlags(fs *flag.FlagSet) {
+	cmd.fs = fs
+	cmd.id = fs.String("case", "", "Case ID")
+	cmd.fileId = fs.String("file", "", "File ID of the report")
+	cmd.token = fs.String("token", "", "Case authentication token, prefer $MAYDAY_TOKEN or ~/.mayday/credentials")
+	cmd.server = fs.String("server", core.DefaultAPIBaseURL, "Mayday server address")
+}
+
+func (cmd *CatCommand) Run(env core.Environment) {
+	if *cmd.id == "" || *cmd.fileId == "" {
+		fmt.Println("Please specify a Case Id --case and a File Id --file")
+		os.Exit(1)
+	}
+
+	mayday, err := newClient(env, *cmd.server, *cmd.id, *cmd.token)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if cmd.fs.NArg() == 0 {
+		entries, err := mayday.APIClient.Entries(*cmd.fileId)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		for _, entry := range entries {
+			fmt.Printf("%-5s %10s %s\n", entry.Type, core.FormatBytes(entry.Size), entry.Path)
+		}
+		return
+	}
+
+	content, err := mayday.APIClient.Entry(*cmd.fileId, cmd.fs.Arg(0))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	defer content.Close()
+
+	if _, err := io.Copy(os.Stdout, content); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,14 @@
+package commands
+
+import (
+	"mayday/core"
+)
+
+func newClient(env core.Environment, server string, id string, token string) (*core.Client, error) {
+	token, err := core.ResolveToken(env, server, id, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return core.NewClient(env, server, id, token)
+}
@@ -0,0 +1,123 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"mayday/core"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type CommentCommand struct {
+	fs      *flag.FlagSet
+	id      *string
+	token   *string
+	server  *string
+	fileId  *string
+	path    *string
+	private *bool
+}
+
+func (cmd *CommentCommand) Name() string {
+	return "comment"
+}
+
+func (cmd *CommentCommand) Description() string {
+	return "Discuss a case: comment add [markdown text, or stdin]|list"
+}
+
+func (cmd *CommentCommand) DefineFlags(fs *flag.FlagSet) {
+	cmd.fs = fs
+	cmd.id = fs.String("case", "", "Case ID")
+	cmd.token = fs.String("token", "", "API key, prefer $MAYDAY_TOKEN or ~/.mayday/credentials")
+	cmd.server = fs.String("server", core.DefaultAPIBaseURL, "Mayday server address")
+	cmd.fileId = fs.String("file-id", "", "File the comment refers to")
+	cmd.path = fs.String("path", "", "Path inside the report the comment refers to")
+	cmd.private = fs.Bool("private", false, "Private note, hidden from customers")
+}
+
+func (cmd *CommentCommand) Run(env core.Environment) {
+	action := cmd.fs.Arg(0)
+	if action != "" {
+		cmd.fs.Parse(cmd.fs.Args()[1:])
+	}
+
+	if *cmd.id == "" {
+		fmt.Println("Please specify a Case Id --case")
+		os.Exit(1)
+	}
+
+	mayday, err := newClient(env, *cmd.server, *cmd.id, *cmd.token)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	switch action {
+	case "add":
+		err = cmd.add(mayday, cmd.fs.Args())
+	case "list":
+		err = cmd.list(mayday)
+	default:
+		fmt.Println("Please specify one of: add, list")
+		os.Exit(1)
+	}
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func (cmd *CommentCommand) add(mayday *core.Client, args []string) error {
+	body := strings.Join(args, " ")
+	if body == "" {
+		readed, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		body = string(readed)
+	}
+
+	request := core.CommentRequest{Body: strings.TrimSpace(body), Path: *cmd.path, Private: *cmd.private}
+	if *cmd.fileId != "" {
+		fileId, err := strconv.Atoi(*cmd.fileId)
+		if err != nil {
+			return fmt.Errorf("invalid file id: %s", *cmd.fileId)
+		}
+		request.FileId = fileId
+	}
+
+	comment, err := mayday.APIClient.AddComment(request)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Comment %d added to case %s\n", comment.Id, *cmd.id)
+	return nil
+}
+
+func (cmd *CommentCommand) list(mayday *core.Client) error {
+	comments, err := mayday.APIClient.Comments(*cmd.fileId)
+	if err != nil {
+		return err
+	}
+
+	for _, comment := range comments {
+		fmt.Printf("#%d %s on %s", comment.Id, comment.Author, comment.Created)
+		if comment.Private {
+			fmt.Printf(" (private)")
+		}
+		if comment.FileId != 0 {
+			fmt.Printf(" about file %d", comment.FileId)
+			if comment.Path != "" {
+				fmt.Printf(":%s", comment.Path)
+			}
+		}
+		fmt.Printf("\n\n%s\n\n", comment.Body)
+	}
+
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"mayday/core"
 	"os"
+	"time"
 )
 
 type CreateCommand struct {
@@ -14,6 +15,10 @@ type CreateCommand struct {
 	config      *string
 	description *string
 	keyid       *string
+	keyring     *string
+	expires     *time.Duration
+	signer      *string
+	program     *string
 }
 
 func (cmd *CreateCommand) Name() string {
@@ -27,6 +32,10 @@ func (cmd *CreateCommand) Description() string {
 func (cmd *CreateCommand) DefineFlags(fs *flag.FlagSet) {
 	cmd.pgp = fs.Bool("pgp", true, "Disable pgp signature validation")
 	cmd.keyid = fs.String("keyid", "", "GPG Key ID to use")
+	cmd.keyring = fs.String("keyring", "", "Keyring, keybox or armored key file holding the secret key")
+	cmd.signer = fs.String("signer", core.SignerOpenPGP, "Signing backend: openpgp, gpg or command")
+	cmd.program = fs.String("signer-program", "", "Program used by the gpg or command signers")
+	cmd.expires = fs.Duration("expires", core.DefaultEnvelopeValidity, "Validity of the signed configuration")
 	cmd.server = fs.String("server", core.DefaultAPIBaseURL, "Mayday server address")
 	cmd.private = fs.Bool("private", false, "Disable pgp signature validation")
 	cmd.description = fs.String("description", "", "Mayday server address")
@@ -34,12 +43,23 @@ func (cmd *CreateCommand) DefineFlags(fs *flag.FlagSet) {
 }
 
 func (cmd *CreateCommand) Run(env core.Environment) {
-	mayday, err := core.NewClient(env, *cmd.server, "", "")
+	mayday, err := newClient(env, *cmd.server, "", "")
 
 	if err != nil {
 		fmt.Println(err)
 	}
 
+	mayday.KeyRing = *cmd.keyring
+	mayday.Validity = *cmd.expires
+
+	if *cmd.pgp {
+		mayday.Signer, err = core.NewSigner(*cmd.signer, *cmd.program, *cmd.keyring)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+	}
+
 	if *cmd.config == "" {
 		fmt.Println("Please specify --config path")
 		os.Exit(-1)
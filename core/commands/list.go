@@ -0,0 +1,113 @@
+package commands
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"mayday/core"
+	"os"
+)
+
+type ListCommand struct {
+	token         *string
+	server        *string
+	owner         *string
+	status        *string
+	createdAfter  *string
+	createdBefore *string
+	text          *string
+	signed        *string
+	private       *string
+	sort          *string
+	cursor        *string
+	limit         *int
+	all           *bool
+	json          *bool
+}
+
+func (cmd *ListCommand) Name() string {
+	return "list"
+}
+
+func (cmd *ListCommand) Description() string {
+	return "List the cases visible with your API key."
+}
+
+func (cmd *ListCommand) DefineFlags(fs *flag.FlagSet) {
+	cmd.token = fs.String("token", "", "API key, prefer $MAYDAY_TOKEN or ~/.mayday/credentials")
+	cmd.server = fs.String("server", core.DefaultAPIBaseURL, "Mayday server address")
+	cmd.owner = fs.String("owner", "", "Only cases owned by this user")
+	cmd.status = fs.String("status", "", "Only cases in this status")
+	cmd.createdAfter = fs.String("created-after", "", "Only cases created at or after this RFC 3339 time")
+	cmd.createdBefore = fs.String("created-before", "", "Only cases created before this RFC 3339 time")
+	cmd.text = fs.String("search", "", "Only cases whose description contains this text")
+	cmd.signed = fs.String("signed", "", "Only signed (true) or unsigned (false) cases")
+	cmd.private = fs.String("private", "", "Only private (true) or public (false) cases")
+	cmd.sort = fs.String("sort", "-created", "Sort by created or updated, prefix with - for descending order")
+	cmd.cursor = fs.String("cursor", "", "Continue a previous listing from this cursor")
+	cmd.limit = fs.Int("limit", 0, "Number of cases per page (server default 50)")
+	cmd.all = fs.Bool("all", false, "Fetch every page")
+	cmd.json = fs.Bool("json", false, "Print the cases as JSON")
+}
+
+func (cmd *ListCommand) Run(env core.Environment) {
+	mayday, err := newClient(env, *cmd.server, "", *cmd.token)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	query := core.CaseQuery{
+		Owner:         *cmd.owner,
+		Status:        *cmd.status,
+		CreatedAfter:  *cmd.createdAfter,
+		CreatedBefore: *cmd.createdBefore,
+		Text:          *cmd.text,
+		Signed:        *cmd.signed,
+		Private:       *cmd.private,
+		Sort:          *cmd.sort,
+		Cursor:        *cmd.cursor,
+		Limit:         *cmd.limit,
+	}
+
+	var cases []core.CaseSummary
+	var next string
+
+	for {
+		list, err := mayday.APIClient.List(query)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		cases = append(cases, list.Cases...)
+		next = list.Next
+
+		if !*cmd.all || next == "" {
+			break
+		}
+		query.Cursor = next
+	}
+
+	if *cmd.json {
+		encoded, err := json.MarshalIndent(core.CaseList{Cases: cases, Next: next}, "", "  ")
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		fmt.Println(string(encoded))
+		return
+	}
+
+	fmt.Printf("%-36s %-26s %-16s %-7s %-8s %-16s %s\n", "ID", "CREATED", "STATUS", "SIGNED", "PRIVATE",
+		"OWNER", "DESCRIPTION")
+	for _, c := range cases {
+		fmt.Printf("%-36s %-26s %-16s %-7t %-8t %-16s %s\n", c.Id, c.Created, c.Status, c.IsSigned, c.IsPrivate,
+			c.Owner, c.Description)
+	}
+
+	if next != "" {
+		fmt.Printf("\nMore cases available, continue with --cursor %s\n", next)
+	}
+}
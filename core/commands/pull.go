@@ -31,7 +31,7 @@ func (cmd *PullCommand) DefineFlags(fs *flag.FlagSet) {
 	cmd.all = fs.Bool("all", true, "Pull all files from the case")
 	cmd.fileId = fs.String("file-id", "", "File Id to retrieve")
 	cmd.to = fs.String("to", "", "Path to store the retrieved files")
-	cmd.token = fs.String("token", "", "PGP KeyID to sign the new configuration")
+	cmd.token = fs.String("token", "", "Case authentication token, prefer $MAYDAY_TOKEN or ~/.mayday/credentials")
 	cmd.server = fs.String("server", core.DefaultAPIBaseURL, "Mayday server address")
 }
 
@@ -41,7 +41,7 @@ func (cmd *PullCommand) Run(env core.Environment) {
 		os.Exit(1)
 	}
 
-	mayday, err := core.NewClient(env, *cmd.server, *cmd.id, *cmd.token)
+	mayday, err := newClient(env, *cmd.server, *cmd.id, *cmd.token)
 	if err != nil {
 		fmt.Println(err)
 	}
@@ -67,9 +67,10 @@ func (cmd *PullCommand) Run(env core.Environment) {
 	base := *cmd.to
 
 	if base == "" {
-		base, err := env.GetDefaultDirectory()
+		base, err = env.GetDefaultDirectory()
 		if err != nil {
 			fmt.Println(err)
+			os.Exit(1)
 		}
 
 		base = path.Join(base, "pull", *cmd.id)
@@ -82,13 +83,29 @@ func (cmd *PullCommand) Run(env core.Environment) {
 
 	}
 
+	writeFiles(base, files)
+}
+
+// writeFiles writes the pulled files under base, refusing any name that
+// would land outside of it. It returns the paths written.
+func writeFiles(base string, files map[string]string) []string {
+	var written []string
+
 	for name, content := range files {
-		filename := path.Join(base, name)
-		err := ioutil.WriteFile(filename, []byte(content), 0700)
+		filename, err := core.ContainedPath(base, name)
+		if err != nil {
+			fmt.Printf("Refusing to write file from server: %s\n", err)
+			continue
+		}
+
+		err = ioutil.WriteFile(filename, []byte(content), 0600)
 		if err != nil {
 			fmt.Println("Error writing file")
 		} else {
 			fmt.Printf("Pulled report on path: %s\n", filename)
+			written = append(written, filename)
 		}
 	}
+
+	return written
 }
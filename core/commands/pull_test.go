@@ -0,0 +1,51 @@
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFilesStaysInBase(t *testing.T) {
+	root, err := ioutil.TempDir("", "mayday-pull")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	base := filepath.Join(root, "pull", "1234")
+	if err := os.MkdirAll(base, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"report.tar.gz":              "report",
+		"../../.ssh/authorized_keys": "ssh-rsa attacker",
+		"../escaped":                 "escaped",
+		filepath.Join(root, "abs"):   "absolute",
+		`..\..\windows`:              "backslashes",
+		`C:\evil`:                    "drive letter",
+		"evil\x00.txt":               "control character",
+	}
+
+	written := writeFiles(base, files)
+
+	if len(written) != 1 || written[0] != filepath.Join(base, "report.tar.gz") {
+		t.Errorf("expected only the report to be written, got %v", written)
+	}
+
+	err = filepath.Walk(root, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() && name != filepath.Join(base, "report.tar.gz") {
+			t.Errorf("unexpected file written: %s", name)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -8,13 +8,17 @@ import (
 )
 
 type RunCommand struct {
-	id      *string
-	pgp     *bool
-	dryRun  *bool
-	server  *string
-	timeout *int
-	token   *string
-	upload  *bool
+	id        *string
+	pgp       *bool
+	dryRun    *bool
+	server    *string
+	timeout   *int
+	token     *string
+	upload    *bool
+	keyring   *string
+	keyserver *string
+	wkd       *string
+	threshold *int
 }
 
 func (cmd *RunCommand) Name() string {
@@ -31,8 +35,12 @@ func (cmd *RunCommand) DefineFlags(fs *flag.FlagSet) {
 	cmd.dryRun = fs.Bool("dry-run", true, "Enable pgp signature validation")
 	cmd.server = fs.String("server", core.DefaultAPIBaseURL, "Mayday server address")
 	cmd.timeout = fs.Int("timeout", 0, "Default timeout for commands")
-	cmd.token = fs.String("token", "", "Authentication token for the case")
+	cmd.token = fs.String("token", "", "Case authentication token, prefer $MAYDAY_TOKEN or ~/.mayday/credentials")
 	cmd.upload = fs.Bool("upload", true, "Upload the generated reports to the server")
+	cmd.keyring = fs.String("keyring", "", "Keyring, keybox or armored key file used to verify the case")
+	cmd.keyserver = fs.String("keyserver", core.DefaultKeyServer, "HKP keyserver used to fetch unknown signer keys")
+	cmd.wkd = fs.String("wkd", "", "Email address to look up unknown signer keys through WKD")
+	cmd.threshold = fs.Int("signatures", 0, "Trusted signatures required, overrides the policy file")
 }
 
 func (cmd *RunCommand) Run(env core.Environment) {
@@ -41,11 +49,25 @@ func (cmd *RunCommand) Run(env core.Environment) {
 		os.Exit(1)
 	}
 
-	mayday, err := core.NewClient(env, *cmd.server, *cmd.id, *cmd.token)
+	mayday, err := newClient(env, *cmd.server, *cmd.id, *cmd.token)
 	if err != nil {
 		fmt.Println(err)
 	}
 
+	mayday.KeyRing = *cmd.keyring
+	mayday.KeyServer = *cmd.keyserver
+	mayday.WKDAddress = *cmd.wkd
+
+	mayday.Policy, err = core.LoadPolicy(env)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if *cmd.threshold > 0 {
+		mayday.Policy.Threshold = *cmd.threshold
+	}
+
 	err = mayday.Run(*cmd.pgp, *cmd.upload, *cmd.timeout, *cmd.dryRun)
 	if err != nil {
 		fmt.Println(err)
@@ -0,0 +1,90 @@
+package commands
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"mayday/core"
+	"os"
+	"strings"
+)
+
+type SearchCommand struct {
+	fs     *flag.FlagSet
+	id     *string
+	token  *string
+	server *string
+	regex  *bool
+	words  *bool
+	limit  *int
+	json   *bool
+}
+
+func (cmd *SearchCommand) Name() string {
+	return "search"
+}
+
+func (cmd *SearchCommand) Description() string {
+	return "Search the uploaded reports of a case, or of every visible case, for text, words or a regular expression."
+}
+
+func (cmd *SearchCommand) DefineFlags(fs *flag.FlagSet) {
+	cmd.fs = fs
+	cmd.id = fs.String("case", "", "Only search this case")
+	cmd.token = fs.String("token", "", "API key, prefer $MAYDAY_TOKEN or ~/.mayday/credentials")
+	cmd.server = fs.String("server", core.DefaultAPIBaseURL, "Mayday server address")
+	cmd.regex = fs.Bool("regex", false, "Read the query as a regular expression")
+	cmd.words = fs.Bool("words", false, "Match whole words only, faster on large reports")
+	cmd.limit = fs.Int("limit", 0, "Maximum number of matching lines (server default 200)")
+	cmd.json = fs.Bool("json", false, "Print the matches as JSON")
+}
+
+func (cmd *SearchCommand) Run(env core.Environment) {
+	query := strings.Join(cmd.fs.Args(), " ")
+	if query == "" {
+		fmt.Println("Please specify what to search for")
+		os.Exit(1)
+	}
+
+	mayday, err := newClient(env, *cmd.server, *cmd.id, *cmd.token)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	result, err := mayday.APIClient.Search(core.SearchQuery{
+		Text:  query,
+		Regex: *cmd.regex,
+		Words: *cmd.words,
+		Case:  *cmd.id,
+		Limit: *cmd.limit,
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if *cmd.json {
+		encoded, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		fmt.Println(string(encoded))
+		return
+	}
+
+	for _, match := range result.Matches {
+		fmt.Printf("%s file %d (%s) %s:%d: %s\n", match.Case, match.FileId, match.File, match.Entry, match.Line,
+			match.Text)
+	}
+
+	if result.Truncated {
+		fmt.Fprintln(os.Stderr, "More matches available, narrow the search or raise --limit")
+	}
+
+	if result.Indexing > 0 {
+		fmt.Fprintf(os.Stderr, "%d reports are still being indexed and were not searched\n", result.Indexing)
+	}
+}
@@ -2,14 +2,24 @@ package commands
 
 import (
 	"flag"
+	"fmt"
 	"mayday/core"
 	"mayday/server"
+	"os"
+	"time"
 )
 
 type ServerCommand struct {
+	fs      *flag.FlagSet
+	migrate *bool
 	port    *int
 	bind    *string
 	storage *string
+	backend *string
+	admin   *string
+	config  *string
+	driver  *string
+	dsn     *string
 }
 
 func (cmd *ServerCommand) Name() string {
@@ -17,15 +27,143 @@ func (cmd *ServerCommand) Name() string {
 }
 
 func (cmd *ServerCommand) Description() string {
-	return "Start a mayday server."
+	return "Start a mayday server, or manage its schema with: server migrate status|up|down"
 }
 
 func (cmd *ServerCommand) DefineFlags(fs *flag.FlagSet) {
-	cmd.storage = fs.String("storage", "", "Storage path for case report files")
-	cmd.port = fs.Int("port", server.DefaultPort, "Port to bind the mayday server")
-	cmd.bind = fs.String("bind", "0.0.0.0", "Address to bind the mayday server")
+	cmd.fs = fs
+	cmd.config = fs.String("config", "", "Server configuration file")
+	cmd.migrate = fs.Bool("migrate", true, "Apply pending schema migrations on start")
+	cmd.storage = fs.String("storage", "", "Storage path for case report files with the local backend")
+	cmd.backend = fs.String("storage-backend", "", "Storage backend for case report files: local or s3 (default local)")
+	cmd.port = fs.Int("port", 0, fmt.Sprintf("Port to bind the mayday server (default %d)", server.DefaultPort))
+	cmd.bind = fs.String("bind", "", fmt.Sprintf("Address to bind the mayday server (default %s)", server.DefaultBind))
+	cmd.driver = fs.String("db-driver", "", "Database driver: sqlite3, postgres or mysql (default sqlite3)")
+	cmd.dsn = fs.String("db-dsn", "", "Database data source name (default ~/.mayday/server.db)")
+	cmd.admin = fs.String("bootstrap-admin", "", "Create the first admin user with this name and print its API key")
+}
+
+func (cmd *ServerCommand) Setup(env core.Environment) *server.Config {
+	config, err := server.LoadConfig(*cmd.config)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if *cmd.bind != "" {
+		config.Bind = *cmd.bind
+	}
+
+	if *cmd.port != 0 {
+		config.Port = *cmd.port
+	}
+
+	if *cmd.storage != "" {
+		config.Storage.Path = *cmd.storage
+	}
+
+	if *cmd.backend != "" {
+		config.Storage.Backend = *cmd.backend
+	}
+
+	if *cmd.driver != "" {
+		config.Database.Driver = *cmd.driver
+	}
+
+	if *cmd.dsn != "" {
+		config.Database.DSN = *cmd.dsn
+	}
+
+	if err := config.SetDefaults(env); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if err := server.SetupDatabase(&config.Database); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	return config
 }
 
 func (cmd *ServerCommand) Run(env core.Environment) {
-	server.Start(env, *cmd.bind, *cmd.port, *cmd.storage)
+	config := cmd.Setup(env)
+	migrator, err := server.NewMigrator(config)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if cmd.fs.Arg(0) == "migrate" {
+		cmd.runMigrate(migrator, cmd.fs.Arg(1))
+		return
+	}
+
+	if *cmd.migrate {
+		if _, err := migrator.Up(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	pending, err := migrator.Pending()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if len(pending) > 0 {
+		fmt.Printf("Database schema has %d pending migrations, run 'mayday server migrate up'\n", len(pending))
+		os.Exit(1)
+	}
+
+	if *cmd.admin != "" {
+		key, err := server.BootstrapAdmin(*cmd.admin)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Admin %s created with API key: %s\n", *cmd.admin, key)
+		return
+	}
+
+	server.Start(config)
+}
+
+func (cmd *ServerCommand) runMigrate(migrator *server.Migrator, action string) {
+	switch action {
+	case "status":
+		status, err := migrator.Status()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		for _, s := range status {
+			applied := "pending"
+			if s.Applied != nil {
+				applied = s.Applied.Applied.Format(time.RFC3339)
+			}
+			fmt.Printf("%4d %-30s %s\n", s.Migration.Version, s.Migration.Name, applied)
+		}
+	case "up":
+		applied, err := migrator.Up()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("Applied %d migrations\n", len(applied))
+	case "down":
+		reverted, err := migrator.Down()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("Reverted migration %d: %s\n", reverted.Version, reverted.Name)
+	default:
+		fmt.Println("Please specify one of: migrate status, migrate up, migrate down")
+		os.Exit(1)
+	}
 }
@@ -23,7 +23,7 @@ func (cmd *ShowCommand) Description() string {
 
 func (cmd *ShowCommand) DefineFlags(fs *flag.FlagSet) {
 	cmd.id = fs.String("id", "", "Case ID")
-	cmd.token = fs.String("token", "", "Case authentication token")
+	cmd.token = fs.String("token", "", "Case authentication token, prefer $MAYDAY_TOKEN or ~/.mayday/credentials")
 	cmd.server = fs.String("server", core.DefaultAPIBaseURL, "Mayday server address")
 }
 
@@ -33,16 +33,57 @@ func (cmd *ShowCommand) Run(env core.Environment) {
 		os.Exit(1)
 	}
 
-	mayday, err := core.NewClient(env, *cmd.server, *cmd.id, *cmd.token)
+	mayday, err := newClient(env, *cmd.server, *cmd.id, *cmd.token)
 
 	if err != nil {
 		fmt.Println(err)
+		os.Exit(1)
 	}
 
 	config, err := mayday.Show()
 	if err != nil {
 		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Case %s", *cmd.id)
+	if config.Status != "" {
+		fmt.Printf(" (%s)", config.Status)
+	}
+	fmt.Printf("\n\nConfiguration:\n%s\n", config.Config)
+
+	if config.Signed != "" {
+		fmt.Printf("Signed: yes\n")
 	}
 
-	fmt.Printf("%s\n", config)
+	fmt.Printf("\nFiles:\n")
+	fmt.Printf("%-6s %-10s %-12s %-20s %-16s %-20s %-8s %-26s %s\n", "ID", "SIZE", "SHA256", "TYPE",
+		"UPLOADER", "HOSTNAME", "VERSION", "CREATED", "NAME")
+	for _, f := range config.Files {
+		digest := f.Digest
+		if len(digest) > 12 {
+			digest = digest[:12]
+		}
+
+		fmt.Printf("%-6d %-10s %-12s %-20s %-16s %-20s %-8s %-26s %s\n", f.Id, core.FormatBytes(f.Size), digest,
+			f.ContentType, f.Uploader, f.Hostname, f.Version, f.Created, f.Path)
+	}
+	fmt.Println()
+
+	if usage := config.Usage; usage != nil {
+		fmt.Printf("Storage used by the case: %s", core.FormatBytes(usage.CaseBytes))
+		if usage.CaseQuota > 0 {
+			fmt.Printf(" of %s", core.FormatBytes(usage.CaseQuota))
+		}
+		fmt.Println()
+
+		if usage.OwnerQuota > 0 {
+			fmt.Printf("Storage used by the case owner: %s of %s\n", core.FormatBytes(usage.OwnerBytes),
+				core.FormatBytes(usage.OwnerQuota))
+		}
+
+		if usage.MaxUploadSize > 0 {
+			fmt.Printf("Maximum upload size: %s\n", core.FormatBytes(usage.MaxUploadSize))
+		}
+	}
 }
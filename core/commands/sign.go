@@ -0,0 +1,67 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"mayday/core"
+	"os"
+	"time"
+)
+
+type SignCommand struct {
+	id      *string
+	token   *string
+	server  *string
+	keyid   *string
+	keyring *string
+	expires *time.Duration
+	signer  *string
+	program *string
+}
+
+func (cmd *SignCommand) Name() string {
+	return "sign"
+}
+
+func (cmd *SignCommand) Description() string {
+	return "Add a co-signature to the configuration of an existing case."
+}
+
+func (cmd *SignCommand) DefineFlags(fs *flag.FlagSet) {
+	cmd.id = fs.String("case", "", "Case ID to co-sign")
+	cmd.token = fs.String("token", "", "Case authentication token, prefer $MAYDAY_TOKEN or ~/.mayday/credentials")
+	cmd.server = fs.String("server", core.DefaultAPIBaseURL, "Mayday server address")
+	cmd.keyid = fs.String("keyid", "", "GPG Key ID to use")
+	cmd.keyring = fs.String("keyring", "", "Keyring, keybox or armored key file holding the secret key")
+	cmd.signer = fs.String("signer", core.SignerOpenPGP, "Signing backend: openpgp, gpg or command")
+	cmd.program = fs.String("signer-program", "", "Program used by the gpg or command signers")
+	cmd.expires = fs.Duration("expires", core.DefaultEnvelopeValidity, "Validity of the signed configuration")
+}
+
+func (cmd *SignCommand) Run(env core.Environment) {
+	if *cmd.id == "" {
+		fmt.Println("Please specify a Case Id --case")
+		os.Exit(1)
+	}
+
+	mayday, err := newClient(env, *cmd.server, *cmd.id, *cmd.token)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	mayday.KeyRing = *cmd.keyring
+	mayday.Validity = *cmd.expires
+	mayday.Signer, err = core.NewSigner(*cmd.signer, *cmd.program, *cmd.keyring)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if err := mayday.Sign(*cmd.keyid); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Case %s co-signed\n", *cmd.id)
+}
@@ -0,0 +1,125 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"mayday/core"
+	"os"
+	"strings"
+)
+
+type TokenCommand struct {
+	fs          *flag.FlagSet
+	id          *string
+	token       *string
+	server      *string
+	scopes      *string
+	expires     *string
+	maxUses     *int
+	description *string
+}
+
+func (cmd *TokenCommand) Name() string {
+	return "token"
+}
+
+func (cmd *TokenCommand) Description() string {
+	return "Manage scoped case tokens: token create|list|revoke [token-id]..."
+}
+
+func (cmd *TokenCommand) DefineFlags(fs *flag.FlagSet) {
+	cmd.fs = fs
+	cmd.id = fs.String("case", "", "Case ID")
+	cmd.token = fs.String("token", "", "API key, prefer $MAYDAY_TOKEN or ~/.mayday/credentials")
+	cmd.server = fs.String("server", core.DefaultAPIBaseURL, "Mayday server address")
+	cmd.scopes = fs.String("scope", "upload", "Comma separated scopes: read, upload, download")
+	cmd.expires = fs.String("expires", "168h", "Token validity")
+	cmd.maxUses = fs.Int("max-uses", 0, "Maximum number of uploads or downloads, 0 for unlimited")
+	cmd.description = fs.String("description", "", "Token description")
+}
+
+func (cmd *TokenCommand) Run(env core.Environment) {
+	action := cmd.fs.Arg(0)
+	if action != "" {
+		cmd.fs.Parse(cmd.fs.Args()[1:])
+	}
+
+	if *cmd.id == "" {
+		fmt.Println("Please specify a Case Id --case")
+		os.Exit(1)
+	}
+
+	mayday, err := newClient(env, *cmd.server, *cmd.id, *cmd.token)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	switch action {
+	case "create":
+		err = cmd.create(mayday)
+	case "list":
+		err = cmd.list(mayday)
+	case "revoke":
+		err = cmd.revoke(mayday, cmd.fs.Args())
+	default:
+		fmt.Println("Please specify one of: create, list, revoke")
+		os.Exit(1)
+	}
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func (cmd *TokenCommand) create(mayday *core.Client) error {
+	created, err := mayday.APIClient.CreateToken(core.TokenRequest{
+		Scopes:      strings.Split(*cmd.scopes, ","),
+		ExpiresIn:   *cmd.expires,
+		MaxUses:     *cmd.maxUses,
+		Description: *cmd.description,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Token %d (%s) expires %s\n", created.Token.Id, created.Token.Scopes, created.Token.Expires)
+	fmt.Printf("Secret: %s\n", created.Secret)
+	return nil
+}
+
+func (cmd *TokenCommand) list(mayday *core.Client) error {
+	tokens, err := mayday.APIClient.Tokens()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%-6s %-22s %-26s %-10s %-8s %s\n", "ID", "SCOPES", "EXPIRES", "USES", "REVOKED", "DESCRIPTION")
+	for _, token := range tokens {
+		uses := fmt.Sprintf("%d", token.Uses)
+		if token.MaxUses > 0 {
+			uses = fmt.Sprintf("%d/%d", token.Uses, token.MaxUses)
+		}
+
+		fmt.Printf("%-6d %-22s %-26s %-10s %-8t %s\n", token.Id, token.Scopes, token.Expires, uses,
+			token.Revoked, token.Description)
+	}
+
+	return nil
+}
+
+func (cmd *TokenCommand) revoke(mayday *core.Client, tokenIds []string) error {
+	if len(tokenIds) == 0 {
+		return fmt.Errorf("Please specify the token ids to revoke")
+	}
+
+	for _, tokenId := range tokenIds {
+		if err := mayday.APIClient.RevokeToken(tokenId); err != nil {
+			return err
+		}
+		fmt.Printf("Token %s revoked\n", tokenId)
+	}
+
+	return nil
+}
@@ -0,0 +1,154 @@
+package commands
+
+import (
+	"code.google.com/p/go.crypto/openpgp"
+	"flag"
+	"fmt"
+	"mayday/core"
+	"os"
+)
+
+type TrustCommand struct {
+	fs        *flag.FlagSet
+	server    *string
+	keyserver *string
+	wkd       *string
+}
+
+func (cmd *TrustCommand) Name() string {
+	return "trust"
+}
+
+func (cmd *TrustCommand) Description() string {
+	return "Manage the keys trusted to sign cases: trust add|list|remove [keyid|keyfile]..."
+}
+
+func (cmd *TrustCommand) DefineFlags(fs *flag.FlagSet) {
+	cmd.fs = fs
+	cmd.server = fs.String("server", "", "Server whose pinned key should be removed")
+	cmd.keyserver = fs.String("keyserver", core.DefaultKeyServer, "HKP keyserver used to fetch keys by id")
+	cmd.wkd = fs.String("wkd", "", "Email address to look up keys through WKD")
+}
+
+func (cmd *TrustCommand) Run(env core.Environment) {
+	trust, err := core.NewTrustStore(env)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	action := cmd.fs.Arg(0)
+	if action != "" {
+		cmd.fs.Parse(cmd.fs.Args()[1:])
+	}
+
+	switch action {
+	case "add":
+		err = cmd.add(trust, cmd.fs.Args())
+	case "list":
+		err = cmd.list(trust)
+	case "remove":
+		err = cmd.remove(trust, cmd.fs.Args())
+	default:
+		fmt.Println("Please specify one of: add, list, remove")
+		os.Exit(1)
+	}
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func (cmd *TrustCommand) add(trust *core.TrustStore, keys []string) error {
+	if len(keys) == 0 {
+		return fmt.Errorf("Please specify a key id or key file to trust")
+	}
+
+	for _, key := range keys {
+		var entities openpgp.EntityList
+
+		if _, err := os.Stat(key); err == nil {
+			keyRing, err := core.ReadKeyRing(key)
+			if err == core.ErrNoKeys {
+				return fmt.Errorf("no keys found in %s", key)
+			} else if err != nil {
+				return err
+			}
+			entities = *keyRing
+		} else {
+			fetcher, err := core.NewKeyFetcher(*cmd.keyserver, *cmd.wkd)
+			if err != nil {
+				return err
+			}
+
+			userKeyRing, err := core.GetDefaultKeyRingPath()
+			if err != nil {
+				return err
+			}
+
+			entity, err := core.LookupKey(fetcher, key, userKeyRing)
+			if err != nil {
+				return err
+			}
+			entities = append(entities, entity)
+		}
+
+		for _, entity := range entities {
+			if err := trust.Add(entity); err != nil {
+				return err
+			}
+			core.PrintKey(entity)
+			fmt.Printf("Trusted key added to %s\n", trust.KeyRingPath)
+		}
+	}
+
+	return nil
+}
+
+func (cmd *TrustCommand) list(trust *core.TrustStore) error {
+	entities, err := trust.Keys()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Trusted keys (%s):\n", trust.KeyRingPath)
+	for _, entity := range entities {
+		core.PrintKey(entity)
+	}
+
+	pins, err := trust.Pins()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\nPinned keys (%s):\n", trust.PinsPath)
+	for server, fingerprint := range pins {
+		fmt.Printf("%s %s\n", server, fingerprint)
+	}
+
+	return nil
+}
+
+func (cmd *TrustCommand) remove(trust *core.TrustStore, keyids []string) error {
+	if *cmd.server != "" {
+		if err := trust.Unpin(*cmd.server); err != nil {
+			return err
+		}
+		fmt.Printf("Removed pinned key for server %s\n", *cmd.server)
+	}
+
+	if len(keyids) == 0 && *cmd.server == "" {
+		return fmt.Errorf("Please specify a key id to remove or a --server pin")
+	}
+
+	for _, keyid := range keyids {
+		entity, err := trust.Remove(keyid)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Removed trusted key %s\n", core.Fingerprint(entity))
+	}
+
+	return nil
+}
@@ -2,6 +2,7 @@ package core
 
 import (
 	"code.google.com/p/go.crypto/openpgp"
+	pgperrors "code.google.com/p/go.crypto/openpgp/errors"
 	"errors"
 	"fmt"
 	goyaml "gopkg.in/yaml.v1"
@@ -23,6 +24,7 @@ type Config struct {
 	Commands      []Command
 	FilesField    []string `yaml:"copy"`
 	CommandsField []string `yaml:"run"`
+	Signatures    int      `yaml:"signatures"`
 }
 
 func NewConfig(readed string) (*Config, error) {
@@ -42,14 +44,12 @@ func NewConfig(readed string) (*Config, error) {
 	return &config, nil
 }
 
-func (c *Config) Sign(keyid string) error {
-	pgp, err := NewPGP()
-
-	if err != nil {
-		return err
+func (c *Config) Sign(signer Signer, envelope *Envelope, keyid string) error {
+	if envelope.ConfigSHA256 != ConfigDigest(c) {
+		return fmt.Errorf("cannot sign configuration: envelope does not match configuration")
 	}
 
-	signed, err := pgp.Sign(c.Raw, keyid)
+	signed, err := signer.Sign(envelope.Raw, keyid)
 	if err != nil {
 		return fmt.Errorf("cannot sign configuration: %s", err)
 	} else {
@@ -59,15 +59,15 @@ func (c *Config) Sign(keyid string) error {
 	return nil
 }
 
-func (c *Config) Verify(signed string) (*openpgp.Entity, error) {
-	pgp, err := NewPGP()
-
-	if err != nil {
-		return nil, err
+func (c *Config) Verify(pgp *PGP, envelope *Envelope, signed string) (*openpgp.Entity, error) {
+	if envelope.ConfigSHA256 != ConfigDigest(c) {
+		return nil, fmt.Errorf("configuration does not match the signed envelope")
 	}
 
-	signature, err := pgp.Verify(c.Raw, signed)
-	if err != nil {
+	signature, err := pgp.Verify(envelope.Raw, signed)
+	if err == pgperrors.ErrUnknownIssuer {
+		return nil, err
+	} else if err != nil {
 		return nil, fmt.Errorf("invalid pgp signature: %s", err)
 	}
 
@@ -0,0 +1,102 @@
+package core
+
+import (
+	"fmt"
+	goyaml "gopkg.in/yaml.v1"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+const (
+	TokenEnvironmentVariable = "MAYDAY_TOKEN"
+	DefaultCredentialsName   = "credentials"
+)
+
+// Credentials is the YAML credentials file kept under the mayday directory:
+//
+//	servers:
+//	  http://localhost:8080:
+//	    token: <default token for the server>
+//	    cases:
+//	      "12": <token for case 12>
+type Credentials struct {
+	Servers map[string]ServerCredentials `yaml:"servers"`
+}
+
+type ServerCredentials struct {
+	Token string            `yaml:"token"`
+	Cases map[string]string `yaml:"cases"`
+}
+
+func GetCredentialsPath(env Environment) (string, error) {
+	base, err := env.GetDefaultDirectory()
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(base, DefaultCredentialsName), nil
+}
+
+func LoadCredentials(env Environment) (*Credentials, error) {
+	credentials := &Credentials{}
+
+	credentialsPath, err := GetCredentialsPath(env)
+	if err != nil {
+		return nil, err
+	}
+
+	readed, err := ioutil.ReadFile(credentialsPath)
+	if os.IsNotExist(err) {
+		return credentials, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if finfo, err := os.Stat(credentialsPath); err == nil && finfo.Mode().Perm()&0077 != 0 {
+		fmt.Fprintf(os.Stderr, "warning: %s is accessible by other users, run chmod 600 on it\n", credentialsPath)
+	}
+
+	if err := goyaml.Unmarshal(readed, credentials); err != nil {
+		return nil, fmt.Errorf("cannot read credentials %s: %s", credentialsPath, err)
+	}
+
+	return credentials, nil
+}
+
+func (c *Credentials) Token(server string, caseId string) string {
+	for name, credentials := range c.Servers {
+		if NormalizeServer(name) != NormalizeServer(server) {
+			continue
+		}
+
+		if token, ok := credentials.Cases[caseId]; ok && caseId != "" {
+			return token
+		}
+
+		return credentials.Token
+	}
+
+	return ""
+}
+
+// ResolveToken picks the authentication token for a case: an explicit
+// --token, then $MAYDAY_TOKEN, then the credentials file.
+func ResolveToken(env Environment, server string, caseId string, token string) (string, error) {
+	if token != "" {
+		fmt.Fprintf(os.Stderr, "warning: --token is visible in shell history and process lists, "+
+			"prefer $%s or the credentials file\n", TokenEnvironmentVariable)
+		return token, nil
+	}
+
+	if token := os.Getenv(TokenEnvironmentVariable); token != "" {
+		return token, nil
+	}
+
+	credentials, err := LoadCredentials(env)
+	if err != nil {
+		return "", err
+	}
+
+	return credentials.Token(server, caseId), nil
+}
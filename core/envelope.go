@@ -0,0 +1,89 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultEnvelopeValidity = 7 * 24 * time.Hour
+)
+
+// Envelope is the signed statement binding a case configuration to the case
+// and server it was issued for and to a validity window. Signatures cover
+// Raw, the exact serialized envelope stored on the server.
+type Envelope struct {
+	Raw          string `json:"-"`
+	CaseId       string
+	Server       string
+	IssuedAt     time.Time
+	ExpiresAt    time.Time
+	ConfigSHA256 string
+}
+
+func ConfigDigest(config *Config) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(config.Raw)))
+}
+
+func NormalizeServer(server string) string {
+	return strings.TrimRight(strings.ToLower(server), "/")
+}
+
+func NewEnvelope(caseId string, server string, config *Config, issuedAt time.Time, validity time.Duration) (*Envelope, error) {
+	if validity <= 0 {
+		validity = DefaultEnvelopeValidity
+	}
+
+	envelope := &Envelope{
+		CaseId:       caseId,
+		Server:       NormalizeServer(server),
+		IssuedAt:     issuedAt.UTC(),
+		ExpiresAt:    issuedAt.Add(validity).UTC(),
+		ConfigSHA256: ConfigDigest(config),
+	}
+
+	raw, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	envelope.Raw = string(raw)
+	return envelope, nil
+}
+
+func ParseEnvelope(raw string) (*Envelope, error) {
+	envelope := &Envelope{Raw: raw}
+
+	if err := json.Unmarshal([]byte(raw), envelope); err != nil {
+		return nil, fmt.Errorf("cannot read signed envelope: %s", err)
+	}
+
+	return envelope, nil
+}
+
+func (e *Envelope) Check(caseId string, server string, config *Config, now time.Time) error {
+	if e.CaseId != caseId {
+		return fmt.Errorf("configuration was signed for case %s, not %s", e.CaseId, caseId)
+	}
+
+	if e.Server != NormalizeServer(server) {
+		return fmt.Errorf("configuration was signed for server %s, not %s", e.Server, server)
+	}
+
+	if e.ConfigSHA256 != ConfigDigest(config) {
+		return fmt.Errorf("configuration does not match the signed envelope")
+	}
+
+	if now.Before(e.IssuedAt) {
+		return fmt.Errorf("configuration signature is not valid before %s", e.IssuedAt)
+	}
+
+	if !now.Before(e.ExpiresAt) {
+		return fmt.Errorf("configuration signature expired at %s", e.ExpiresAt)
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package core
+
+import (
+	"bytes"
+	"code.google.com/p/go.crypto/openpgp"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	keyboxBlobHeader  = 1
+	keyboxBlobOpenPGP = 2
+	keyboxMagic       = "KBXf"
+)
+
+func IsKeybox(data []byte) bool {
+	return len(data) >= 12 && data[4] == keyboxBlobHeader && string(data[8:12]) == keyboxMagic
+}
+
+func IsArmored(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN PGP"))
+}
+
+// ReadKeybox extracts the OpenPGP keyblocks stored in a GnuPG 2.1+ keybox
+// (pubring.kbx). X.509 blobs are skipped.
+func ReadKeybox(data []byte) (openpgp.EntityList, error) {
+	var entities openpgp.EntityList
+
+	for offset := 0; offset < len(data); {
+		if len(data)-offset < 6 {
+			return nil, fmt.Errorf("truncated keybox blob at offset %d", offset)
+		}
+
+		length := int(binary.BigEndian.Uint32(data[offset:]))
+		if length < 6 || offset+length > len(data) {
+			return nil, fmt.Errorf("invalid keybox blob length %d at offset %d", length, offset)
+		}
+
+		blob := data[offset : offset+length]
+		offset += length
+
+		if blob[4] != keyboxBlobOpenPGP {
+			continue
+		}
+
+		if len(blob) < 16 {
+			return nil, fmt.Errorf("truncated keybox openpgp blob")
+		}
+
+		start := int(binary.BigEndian.Uint32(blob[8:]))
+		size := int(binary.BigEndian.Uint32(blob[12:]))
+		if start+size > len(blob) {
+			return nil, fmt.Errorf("invalid keybox keyblock bounds")
+		}
+
+		keyblock, err := openpgp.ReadKeyRing(bytes.NewReader(blob[start : start+size]))
+		if err != nil {
+			return nil, fmt.Errorf("cannot read keybox keyblock: %s", err)
+		}
+
+		entities = append(entities, keyblock...)
+	}
+
+	return entities, nil
+}
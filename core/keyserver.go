@@ -0,0 +1,256 @@
+package core
+
+import (
+	"bytes"
+	"code.google.com/p/go.crypto/openpgp"
+	"code.google.com/p/go.crypto/openpgp/armor"
+	"code.google.com/p/go.crypto/openpgp/packet"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultKeyServer = "hkps://keys.openpgp.org"
+	DefaultHKPPort   = "11371"
+	zbase32Alphabet  = "ybndrfg8ejkmcpqxot1uwisza345h769"
+)
+
+type KeyFetcher interface {
+	Fetch(keyid string) (openpgp.EntityList, error)
+}
+
+type HKPFetcher struct {
+	Server string
+	Client *http.Client
+}
+
+// WKDFetcher looks a key up through the OpenPGP Web Key Directory of the
+// domain in Address, trying the advanced method before the direct one.
+type WKDFetcher struct {
+	Address string
+	Client  *http.Client
+}
+
+func NewKeyFetcher(keyserver string, wkd string) (KeyFetcher, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	if wkd != "" {
+		if !strings.Contains(wkd, "@") {
+			return nil, fmt.Errorf("invalid WKD address: %s", wkd)
+		}
+		return &WKDFetcher{Address: wkd, Client: client}, nil
+	}
+
+	if keyserver == "" {
+		keyserver = DefaultKeyServer
+	}
+
+	return &HKPFetcher{Server: keyserver, Client: client}, nil
+}
+
+// LookupURL builds the HKP lookup of keyid. Servers without a scheme, like
+// "keys.example.com" or "host:port", are HKP servers.
+func (f *HKPFetcher) LookupURL(keyid string) (string, error) {
+	server := f.Server
+	if !strings.Contains(server, "://") {
+		server = "hkp://" + server
+	}
+
+	u, err := url.Parse(server)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid keyserver %s", f.Server)
+	}
+
+	switch u.Scheme {
+	case "hkps":
+		u.Scheme = "https"
+	case "hkp":
+		u.Scheme = "http"
+		if _, _, err := net.SplitHostPort(u.Host); err != nil {
+			u.Host = net.JoinHostPort(u.Host, DefaultHKPPort)
+		}
+	case "http", "https":
+	default:
+		return "", fmt.Errorf("unsupported keyserver scheme: %s", u.Scheme)
+	}
+
+	u.Path = "/pks/lookup"
+	u.RawQuery = url.Values{
+		"op":      {"get"},
+		"options": {"mr"},
+		"search":  {"0x" + NormalizeKeyId(keyid)},
+	}.Encode()
+
+	return u.String(), nil
+}
+
+func (f *HKPFetcher) Fetch(keyid string) (openpgp.EntityList, error) {
+	lookup, err := f.LookupURL(keyid)
+	if err != nil {
+		return nil, err
+	}
+
+	readed, err := httpGet(f.Client, lookup)
+	if err != nil {
+		return nil, err
+	}
+
+	return openpgp.ReadArmoredKeyRing(bytes.NewReader(readed))
+}
+
+func (f *WKDFetcher) LookupURLs() ([]string, error) {
+	parts := strings.SplitN(f.Address, "@", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid WKD address: %s", f.Address)
+	}
+
+	local := parts[0]
+	domain := strings.ToLower(parts[1])
+	hash := sha1.Sum([]byte(strings.ToLower(local)))
+	query := url.Values{"l": {local}}.Encode()
+	hu := ZBase32Encode(hash[:])
+
+	return []string{
+		fmt.Sprintf("https://openpgpkey.%s/.well-known/openpgpkey/%s/hu/%s?%s", domain, domain, hu, query),
+		fmt.Sprintf("https://%s/.well-known/openpgpkey/hu/%s?%s", domain, hu, query),
+	}, nil
+}
+
+func (f *WKDFetcher) Fetch(keyid string) (openpgp.EntityList, error) {
+	lookups, err := f.LookupURLs()
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, lookup := range lookups {
+		readed, err := httpGet(f.Client, lookup)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if IsArmored(readed) {
+			return openpgp.ReadArmoredKeyRing(bytes.NewReader(readed))
+		}
+		return openpgp.ReadKeyRing(bytes.NewReader(readed))
+	}
+
+	return nil, lastErr
+}
+
+func httpGet(client *http.Client, lookup string) ([]byte, error) {
+	response, err := client.Get(lookup)
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("key lookup on %s failed: %s", lookup, response.Status)
+	}
+
+	return ioutil.ReadAll(response.Body)
+}
+
+func ZBase32Encode(data []byte) string {
+	var encoded []byte
+	var buffer, bits uint
+
+	for _, b := range data {
+		buffer = buffer<<8 | uint(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			encoded = append(encoded, zbase32Alphabet[(buffer>>bits)&31])
+		}
+	}
+
+	if bits > 0 {
+		encoded = append(encoded, zbase32Alphabet[(buffer<<(5-bits))&31])
+	}
+
+	return string(encoded)
+}
+
+func SignatureIssuer(signed string) (string, error) {
+	block, err := armor.Decode(strings.NewReader(signed))
+	if err != nil {
+		return "", fmt.Errorf("cannot decode signature: %s", err)
+	}
+
+	p, err := packet.Read(block.Body)
+	if err != nil {
+		return "", fmt.Errorf("cannot read signature: %s", err)
+	}
+
+	sig, ok := p.(*packet.Signature)
+	if !ok || sig.IssuerKeyId == nil {
+		return "", fmt.Errorf("signature does not carry an issuer key id")
+	}
+
+	return fmt.Sprintf("%016X", *sig.IssuerKeyId), nil
+}
+
+func HasIssuer(entity *openpgp.Entity, keyid string) bool {
+	if MatchKeyId(entity, keyid) {
+		return true
+	}
+
+	for _, subkey := range entity.Subkeys {
+		if subkey.PublicKey.KeyIdString() == NormalizeKeyId(keyid) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func FindKey(entities openpgp.EntityList, keyid string) *openpgp.Entity {
+	for _, entity := range entities {
+		if HasIssuer(entity, keyid) {
+			return entity
+		}
+	}
+
+	return nil
+}
+
+// LookupKey searches the given local keyrings for keyid and falls back to
+// the fetcher. Local keyrings are only a source of keys, never of trust.
+func LookupKey(fetcher KeyFetcher, keyid string, keyRingPaths ...string) (*openpgp.Entity, error) {
+	for _, keyRingPath := range keyRingPaths {
+		if _, err := os.Stat(keyRingPath); err != nil {
+			continue
+		}
+
+		entities, err := ReadKeyRing(keyRingPath)
+		if err != nil {
+			continue
+		}
+
+		if entity := FindKey(*entities, keyid); entity != nil {
+			return entity, nil
+		}
+	}
+
+	entities, err := fetcher.Fetch(keyid)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch key %s: %s", keyid, err)
+	}
+
+	entity := FindKey(entities, keyid)
+	if entity == nil {
+		return nil, fmt.Errorf("keyserver did not return key %s", keyid)
+	}
+
+	return entity, nil
+}
@@ -0,0 +1,70 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestHKPLookupURL(t *testing.T) {
+	tests := []struct {
+		server string
+		want   string
+	}{
+		{"hkps://keys.openpgp.org", "https://keys.openpgp.org/pks/lookup?op=get&options=mr&search=0xDEADBEEF"},
+		{"hkp://pool.sks-keyservers.net", "http://pool.sks-keyservers.net:11371/pks/lookup?op=get&options=mr&search=0xDEADBEEF"},
+		{"keys.example.com", "http://keys.example.com:11371/pks/lookup?op=get&options=mr&search=0xDEADBEEF"},
+		{"keys.example.com:8080", "http://keys.example.com:8080/pks/lookup?op=get&options=mr&search=0xDEADBEEF"},
+		{"localhost:11371", "http://localhost:11371/pks/lookup?op=get&options=mr&search=0xDEADBEEF"},
+		{"https://keys.example.com", "https://keys.example.com/pks/lookup?op=get&options=mr&search=0xDEADBEEF"},
+	}
+
+	for _, test := range tests {
+		fetcher := &HKPFetcher{Server: test.server}
+
+		got, err := fetcher.LookupURL("0xdeadbeef")
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.server, err)
+			continue
+		}
+
+		if got != test.want {
+			t.Errorf("%s: got %s, want %s", test.server, got, test.want)
+		}
+	}
+}
+
+func TestHKPLookupURLInvalid(t *testing.T) {
+	for _, server := range []string{"ftp://keys.example.com", "hkp://", "://"} {
+		fetcher := &HKPFetcher{Server: server}
+
+		if _, err := fetcher.LookupURL("DEADBEEF"); err == nil {
+			t.Errorf("%s: expected an error", server)
+		}
+	}
+}
+
+func TestPublicKeysMissingKeyRings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mayday-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	empty := path.Join(dir, "empty.gpg")
+	if err := ioutil.WriteFile(empty, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &PGP{KeyRingPath: path.Join(dir, "missing.gpg"), ExtraKeyRingPaths: []string{empty}}
+
+	entities, err := p.PublicKeys()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(*entities) != 0 {
+		t.Errorf("expected no keys, got %d", len(*entities))
+	}
+}
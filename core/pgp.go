@@ -5,16 +5,25 @@ import (
 	"code.google.com/p/go.crypto/openpgp"
 	"code.google.com/p/gopass"
 	"fmt"
+	"io/ioutil"
 	"os"
-	"os/exec"
 	"os/user"
 	"path"
 	"strings"
 )
 
+const (
+	SignatureBegin = "-----BEGIN PGP SIGNATURE-----"
+	SignatureEnd   = "-----END PGP SIGNATURE-----"
+)
+
+// ErrNoKeys is returned when a keyring holds no key at all.
+var ErrNoKeys = fmt.Errorf("no keys found")
+
 type PGP struct {
-	SecKeyRingPath string
-	KeyRingPath    string
+	SecKeyRingPath    string
+	KeyRingPath       string
+	ExtraKeyRingPaths []string
 }
 
 type PGPSignature struct {
@@ -22,23 +31,48 @@ type PGPSignature struct {
 	KeyId uint64
 }
 
-func GetDefaultKeyRingPath() (string, error) {
+func GetGnuPGHome() (string, error) {
+	if home := os.Getenv("GNUPGHOME"); home != "" {
+		return home, nil
+	}
+
 	usr, err := user.Current()
 	if err != nil {
 		return "", err
 	}
-	return path.Join(usr.HomeDir, ".gnupg", "pubring.gpg"), nil
+	return path.Join(usr.HomeDir, ".gnupg"), nil
+}
+
+func GetDefaultKeyRingPath() (string, error) {
+	home, err := GetGnuPGHome()
+	if err != nil {
+		return "", err
+	}
+
+	keybox := path.Join(home, "pubring.kbx")
+	if _, err := os.Stat(keybox); err == nil {
+		return keybox, nil
+	}
+
+	return path.Join(home, "pubring.gpg"), nil
 }
 
 func GetDefaultSecKeyRingPath() (string, error) {
-	usr, err := user.Current()
+	home, err := GetGnuPGHome()
 	if err != nil {
 		return "", err
 	}
-	return path.Join(usr.HomeDir, ".gnupg", "secring.gpg"), nil
+	return path.Join(home, "secring.gpg"), nil
 }
 
-func NewPGP() (*PGP, error) {
+func NewPGP(keyRing string) (*PGP, error) {
+	if keyRing != "" {
+		return &PGP{
+			KeyRingPath:    keyRing,
+			SecKeyRingPath: keyRing,
+		}, nil
+	}
+
 	defaultKeyRingPath, err := GetDefaultKeyRingPath()
 	if err != nil {
 		return nil, err
@@ -55,58 +89,93 @@ func NewPGP() (*PGP, error) {
 	}, nil
 }
 
+// ReadKeyRing loads a binary keyring, a GnuPG keybox or an ASCII-armored
+// key file, detecting the format from its contents.
 func ReadKeyRing(keyRingPath string) (*openpgp.EntityList, error) {
-
-	defaultKeyRing, err := os.Open(keyRingPath)
+	readed, err := ioutil.ReadFile(keyRingPath)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("cannot read keyring %s: %s", keyRingPath, err)
 	}
 
-	defer defaultKeyRing.Close()
+	var entityList openpgp.EntityList
+
+	switch {
+	case IsKeybox(readed):
+		entityList, err = ReadKeybox(readed)
+	case IsArmored(readed):
+		entityList, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(readed))
+	default:
+		entityList, err = openpgp.ReadKeyRing(bytes.NewReader(readed))
+	}
 
-	entityList, err := openpgp.ReadKeyRing(defaultKeyRing)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("cannot parse keyring %s: %s", keyRingPath, err)
+	}
+
+	if len(entityList) == 0 {
+		return nil, ErrNoKeys
 	}
 
 	return &entityList, nil
 }
 
-func FetchKey(keyid string) error {
-	var reply string
+func ConfirmKey(entities []*openpgp.Entity, config *Config) bool {
+	var answer string
+
+	for _, entity := range entities {
+		fmt.Printf("Configuration file Signed-off by PGP Key: %s\n", entity.PrimaryKey.KeyIdShortString())
 
-	fmt.Printf("PGP Key:%s was not found on your keyring, Do you want to import it? (y/n) ", keyid)
-	fmt.Scanf("%s", &reply)
-	if reply != "y" {
-		return fmt.Errorf("Key %s not found and user skipped importing", keyid)
+		for _, identity := range entity.Identities {
+			fmt.Printf("* %s\n", identity.Name)
+		}
 	}
 
-	_, err := exec.Command("gpg", "--recv-keys", keyid).Output()
+	fmt.Printf("Proceed (y/n)? ")
+	fmt.Scanf("%s", &answer)
+	return answer == "y"
+}
 
-	if err != nil {
-		return fmt.Errorf("Cannot import key %s from public servers", keyid)
+func SplitSignatures(signed string) []string {
+	var signatures []string
+
+	for _, part := range strings.SplitAfter(signed, SignatureEnd) {
+		if start := strings.Index(part, SignatureBegin); start >= 0 && strings.HasSuffix(part, SignatureEnd) {
+			signatures = append(signatures, strings.TrimSpace(part[start:])+"\n")
+		}
 	}
 
-	fmt.Printf("PGP Key: %s imported correctly into the keyring\n", keyid)
-	return nil
+	return signatures
 }
 
-func ConfirmKey(entity *openpgp.Entity, config *Config) bool {
-	var answer string
-	fmt.Printf("Configuration file Signed-off by PGP Key: %s\n", entity.PrimaryKey.KeyIdShortString())
+func JoinSignatures(signatures ...string) string {
+	var joined []string
 
-	for _, identity := range entity.Identities {
-		fmt.Printf("* %s\n", identity.Name)
+	for _, signature := range signatures {
+		for _, split := range SplitSignatures(signature) {
+			joined = append(joined, split)
+		}
 	}
 
-	fmt.Printf("Proceed (y/n)? ")
-	fmt.Scanf("%s", &answer)
-	return answer == "y"
+	return strings.Join(joined, "")
+}
+
+func NormalizeKeyId(keyid string) string {
+	keyid = strings.ToUpper(strings.TrimSpace(keyid))
+	return strings.TrimPrefix(keyid, "0X")
+}
+
+func MatchKeyId(entity *openpgp.Entity, keyid string) bool {
+	keyid = NormalizeKeyId(keyid)
+	fingerprint := fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint)
+
+	return keyid == entity.PrimaryKey.KeyIdShortString() ||
+		keyid == entity.PrimaryKey.KeyIdString() ||
+		keyid == fingerprint
 }
 
 func HasKey(keyid string, entities *openpgp.EntityList) (*openpgp.Entity, error) {
 	for _, entity := range *entities {
-		if entity.PrimaryKey.CanSign() && entity.PrimaryKey.KeyIdShortString() == keyid {
+		if entity.PrimaryKey.CanSign() && MatchKeyId(entity, keyid) {
 			return entity, nil
 		}
 	}
@@ -114,26 +183,54 @@ func HasKey(keyid string, entities *openpgp.EntityList) (*openpgp.Entity, error)
 	return nil, fmt.Errorf("cannot find key id: %s", keyid)
 }
 
+func HasSecretKey(keyid string, entities *openpgp.EntityList) (*openpgp.Entity, error) {
+	for _, entity := range *entities {
+		if entity.PrivateKey == nil || !entity.PrimaryKey.CanSign() {
+			continue
+		}
+
+		if keyid == "" || MatchKeyId(entity, keyid) {
+			return entity, nil
+		}
+	}
+
+	if keyid == "" {
+		return nil, fmt.Errorf("cannot find any secret signing key")
+	}
+
+	return nil, fmt.Errorf("cannot find secret key for key id: %s", keyid)
+}
+
 func (p *PGP) Sign(readed string, keyid string) (string, error) {
-	entities, err := ReadKeyRing(p.SecKeyRingPath)
-	if err != nil {
-		return "", nil
+	if _, err := os.Stat(p.SecKeyRingPath); os.IsNotExist(err) {
+		return "", fmt.Errorf("secret keyring %s does not exist; GnuPG 2.1+ keeps private keys "+
+			"in gpg-agent (private-keys-v1.d), export one with 'gpg --export-secret-keys --armor' "+
+			"and pass it with --keyring", p.SecKeyRingPath)
 	}
 
-	entity, err := HasKey(keyid, entities)
-	if err != nil {
+	entities, err := ReadKeyRing(p.SecKeyRingPath)
+	if err == ErrNoKeys {
+		return "", fmt.Errorf("no keys found in keyring %s", p.SecKeyRingPath)
+	} else if err != nil {
 		return "", err
 	}
 
-	password, err := gopass.GetPass(fmt.Sprintf("Please insert password for key with id '%s': ",
-		entity.PrimaryKey.KeyIdShortString()))
+	entity, err := HasSecretKey(keyid, entities)
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("%s in %s", err, p.SecKeyRingPath)
 	}
 
-	err = entity.PrivateKey.Decrypt([]byte(password))
-	if err != nil {
-		return "", err
+	if entity.PrivateKey.Encrypted {
+		password, err := gopass.GetPass(fmt.Sprintf("Please insert password for key with id '%s': ",
+			entity.PrimaryKey.KeyIdShortString()))
+		if err != nil {
+			return "", err
+		}
+
+		err = entity.PrivateKey.Decrypt([]byte(password))
+		if err != nil {
+			return "", err
+		}
 	}
 
 	buff := new(bytes.Buffer)
@@ -144,8 +241,33 @@ func (p *PGP) Sign(readed string, keyid string) (string, error) {
 	return buff.String(), nil
 }
 
+// PublicKeys loads the verification keyrings. Missing or empty keyrings are
+// skipped, so verifying against them fails with ErrUnknownIssuer and the
+// signer key can be fetched.
+func (p *PGP) PublicKeys() (*openpgp.EntityList, error) {
+	var entities openpgp.EntityList
+
+	keyRingPaths := append([]string{p.KeyRingPath}, p.ExtraKeyRingPaths...)
+	for _, keyRingPath := range keyRingPaths {
+		if finfo, err := os.Stat(keyRingPath); err != nil || finfo.Size() == 0 {
+			continue
+		}
+
+		keyRing, err := ReadKeyRing(keyRingPath)
+		if err == ErrNoKeys {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		entities = append(entities, *keyRing...)
+	}
+
+	return &entities, nil
+}
+
 func (p *PGP) Verify(readed string, signed string) (*openpgp.Entity, error) {
-	entities, err := ReadKeyRing(p.KeyRingPath)
+	entities, err := p.PublicKeys()
 
 	if err != nil {
 		return nil, err
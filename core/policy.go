@@ -0,0 +1,99 @@
+package core
+
+import (
+	"code.google.com/p/go.crypto/openpgp"
+	"fmt"
+	goyaml "gopkg.in/yaml.v1"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	DefaultPolicyName = "policy.yaml"
+	DefaultThreshold  = 1
+)
+
+// Policy is the client-side signing policy: how many distinct trusted keys
+// must have signed a case before any of its commands are executed. When Keys
+// lists fingerprints, only signatures by those team keys count.
+type Policy struct {
+	Threshold int      `yaml:"threshold"`
+	Keys      []string `yaml:"keys"`
+}
+
+func LoadPolicy(env Environment) (*Policy, error) {
+	policy := &Policy{Threshold: DefaultThreshold}
+
+	base, err := env.GetDefaultDirectory()
+	if err != nil {
+		return nil, err
+	}
+
+	policyPath := path.Join(base, DefaultPolicyName)
+	readed, err := ioutil.ReadFile(policyPath)
+	if os.IsNotExist(err) {
+		return policy, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := goyaml.Unmarshal(readed, policy); err != nil {
+		return nil, fmt.Errorf("cannot read policy %s: %s", policyPath, err)
+	}
+
+	return policy, nil
+}
+
+// Required returns the number of signatures needed for config, which may
+// raise but never lower the policy threshold.
+func (p *Policy) Required(config *Config) int {
+	required := p.Threshold
+	if required < DefaultThreshold {
+		required = DefaultThreshold
+	}
+
+	if config.Signatures > required {
+		required = config.Signatures
+	}
+
+	return required
+}
+
+// Counted returns the signers that count towards the threshold.
+func (p *Policy) Counted(signers []*openpgp.Entity) []*openpgp.Entity {
+	if len(p.Keys) == 0 {
+		return signers
+	}
+
+	keys := make(map[string]bool, len(p.Keys))
+	for _, key := range p.Keys {
+		keys[strings.Replace(NormalizeKeyId(key), " ", "", -1)] = true
+	}
+
+	var counted []*openpgp.Entity
+	for _, signer := range signers {
+		if keys[Fingerprint(signer)] {
+			counted = append(counted, signer)
+		}
+	}
+
+	return counted
+}
+
+func (p *Policy) Enforce(config *Config, signers []*openpgp.Entity) error {
+	required := p.Required(config)
+
+	counted := p.Counted(signers)
+	if len(counted) < required {
+		if len(p.Keys) > 0 {
+			return fmt.Errorf("configuration requires %d signatures from the %d team keys of the policy, found %d",
+				required, len(p.Keys), len(counted))
+		}
+		return fmt.Errorf("configuration requires %d signatures from trusted keys, found %d",
+			required, len(counted))
+	}
+
+	return nil
+}
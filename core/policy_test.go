@@ -0,0 +1,46 @@
+package core
+
+import (
+	"code.google.com/p/go.crypto/openpgp"
+	"strings"
+	"testing"
+)
+
+func newTestEntity(t *testing.T, name string) *openpgp.Entity {
+	entity, err := openpgp.NewEntity(name, "", name+"@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entity
+}
+
+func TestPolicyEnforce(t *testing.T) {
+	alice := newTestEntity(t, "alice")
+	bob := newTestEntity(t, "bob")
+	mallory := newTestEntity(t, "mallory")
+
+	tests := []struct {
+		name    string
+		policy  Policy
+		signers []*openpgp.Entity
+		valid   bool
+	}{
+		{"any trusted key", Policy{Threshold: 1}, []*openpgp.Entity{mallory}, true},
+		{"below threshold", Policy{Threshold: 2}, []*openpgp.Entity{alice}, false},
+		{"team keys", Policy{Threshold: 2, Keys: []string{Fingerprint(alice), Fingerprint(bob)}},
+			[]*openpgp.Entity{alice, bob}, true},
+		{"outsider does not count", Policy{Threshold: 2, Keys: []string{Fingerprint(alice), Fingerprint(bob)}},
+			[]*openpgp.Entity{alice, mallory}, false},
+		{"lowercase fingerprint", Policy{Threshold: 1, Keys: []string{"0x" + strings.ToLower(Fingerprint(bob))}},
+			[]*openpgp.Entity{bob}, true},
+	}
+
+	for _, test := range tests {
+		err := test.policy.Enforce(&Config{}, test.signers)
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("%s: expected the policy to fail", test.name)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const (
+	SignerOpenPGP = "openpgp"
+	SignerGPG     = "gpg"
+	SignerCommand = "command"
+
+	DefaultGPGProgram = "gpg"
+)
+
+type Signer interface {
+	Sign(readed string, keyid string) (string, error)
+}
+
+// GPGSigner delegates to `gpg --detach-sign`, so keys held by gpg-agent,
+// smartcards or tokens can be used and passphrases come from pinentry.
+type GPGSigner struct {
+	Program string
+}
+
+// CommandSigner runs an external program as `<program> [args...] sign <keyid>`
+// with the configuration on stdin, and expects an ASCII-armored detached
+// signature on stdout. A non-zero exit status aborts the signature.
+type CommandSigner struct {
+	Program string
+}
+
+func NewSigner(kind string, program string, keyRing string) (Signer, error) {
+	switch kind {
+	case "", SignerOpenPGP:
+		return NewPGP(keyRing)
+	case SignerGPG:
+		if program == "" {
+			program = DefaultGPGProgram
+		}
+		return &GPGSigner{Program: program}, nil
+	case SignerCommand:
+		if program == "" {
+			return nil, fmt.Errorf("signer %s requires a --signer-program", kind)
+		}
+		return &CommandSigner{Program: program}, nil
+	}
+
+	return nil, fmt.Errorf("unknown signer: %s", kind)
+}
+
+func (s *GPGSigner) Sign(readed string, keyid string) (string, error) {
+	args := []string{"--armor", "--detach-sign"}
+	if keyid != "" {
+		args = append(args, "--local-user", keyid)
+	}
+
+	return runSigner(s.Program, args, readed)
+}
+
+func (s *CommandSigner) Sign(readed string, keyid string) (string, error) {
+	fields := strings.Fields(s.Program)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("empty signer program")
+	}
+
+	args := append(fields[1:], "sign", keyid)
+	return runSigner(fields[0], args, readed)
+}
+
+func runSigner(program string, args []string, readed string) (string, error) {
+	stdout := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
+
+	cmd := exec.Command(program, args...)
+	cmd.Stdin = strings.NewReader(readed)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("signer %s failed: %s: %s", program, err, strings.TrimSpace(stderr.String()))
+	}
+
+	signature := stdout.String()
+	if !strings.HasPrefix(strings.TrimSpace(signature), SignatureBegin) {
+		return "", fmt.Errorf("signer %s did not return an armored detached signature", program)
+	}
+
+	return signature, nil
+}
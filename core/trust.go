@@ -0,0 +1,232 @@
+package core
+
+import (
+	"bytes"
+	"code.google.com/p/go.crypto/openpgp"
+	"fmt"
+	goyaml "gopkg.in/yaml.v1"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	DefaultTrustedKeyRingName = "trusted.gpg"
+	DefaultPinsName           = "pins.yaml"
+)
+
+// TrustStore is the mayday-owned set of keys allowed to sign cases, kept
+// apart from the user's GnuPG keyrings, along with the key pinned for each
+// server on first use.
+type TrustStore struct {
+	KeyRingPath string
+	PinsPath    string
+}
+
+func NewTrustStore(env Environment) (*TrustStore, error) {
+	base, err := env.GetDefaultDirectory()
+	if err != nil {
+		return nil, err
+	}
+
+	return &TrustStore{
+		KeyRingPath: path.Join(base, DefaultTrustedKeyRingName),
+		PinsPath:    path.Join(base, DefaultPinsName),
+	}, nil
+}
+
+func Fingerprint(entity *openpgp.Entity) string {
+	return fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint)
+}
+
+func PrintKey(entity *openpgp.Entity) {
+	fmt.Printf("PGP Key: %s\n", entity.PrimaryKey.KeyIdString())
+	fmt.Printf("Fingerprint: %s\n", Fingerprint(entity))
+	for _, identity := range entity.Identities {
+		fmt.Printf("* %s\n", identity.Name)
+	}
+}
+
+func (t *TrustStore) Keys() (openpgp.EntityList, error) {
+	if finfo, err := os.Stat(t.KeyRingPath); err != nil || finfo.Size() == 0 {
+		return openpgp.EntityList{}, nil
+	}
+
+	entities, err := ReadKeyRing(t.KeyRingPath)
+	if err == ErrNoKeys {
+		return openpgp.EntityList{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return *entities, nil
+}
+
+func (t *TrustStore) save(entities openpgp.EntityList) error {
+	buff := new(bytes.Buffer)
+	for _, entity := range entities {
+		if err := entity.Serialize(buff); err != nil {
+			return err
+		}
+	}
+
+	return ioutil.WriteFile(t.KeyRingPath, buff.Bytes(), 0600)
+}
+
+func (t *TrustStore) Add(entity *openpgp.Entity) error {
+	entities, err := t.Keys()
+	if err != nil {
+		return err
+	}
+
+	for _, trusted := range entities {
+		if Fingerprint(trusted) == Fingerprint(entity) {
+			return fmt.Errorf("key %s is already trusted", Fingerprint(entity))
+		}
+	}
+
+	return t.save(append(entities, entity))
+}
+
+func (t *TrustStore) Remove(keyid string) (*openpgp.Entity, error) {
+	entities, err := t.Keys()
+	if err != nil {
+		return nil, err
+	}
+
+	var removed *openpgp.Entity
+	var kept openpgp.EntityList
+
+	for _, entity := range entities {
+		if removed == nil && MatchKeyId(entity, keyid) {
+			removed = entity
+		} else {
+			kept = append(kept, entity)
+		}
+	}
+
+	if removed == nil {
+		return nil, fmt.Errorf("key %s is not trusted", keyid)
+	}
+
+	return removed, t.save(kept)
+}
+
+// Import asks the user to trust entity and adds it to the trusted keyring.
+func (t *TrustStore) Import(entity *openpgp.Entity) error {
+	var reply string
+
+	fmt.Printf("Configuration file signed by a key that is not trusted yet\n")
+	PrintKey(entity)
+	fmt.Printf("Do you want to trust this key to sign mayday cases? (y/n) ")
+	fmt.Scanf("%s", &reply)
+	if reply != "y" {
+		return fmt.Errorf("key %s is not trusted and user skipped importing", entity.PrimaryKey.KeyIdString())
+	}
+
+	if err := t.Add(entity); err != nil {
+		return err
+	}
+
+	fmt.Printf("PGP Key: %s added to %s\n", entity.PrimaryKey.KeyIdString(), t.KeyRingPath)
+	return nil
+}
+
+func (t *TrustStore) Pins() (map[string]string, error) {
+	pins := make(map[string]string)
+
+	readed, err := ioutil.ReadFile(t.PinsPath)
+	if os.IsNotExist(err) {
+		return pins, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := goyaml.Unmarshal(readed, &pins); err != nil {
+		return nil, fmt.Errorf("cannot read pins %s: %s", t.PinsPath, err)
+	}
+
+	return pins, nil
+}
+
+func (t *TrustStore) savePins(pins map[string]string) error {
+	readed, err := goyaml.Marshal(pins)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(t.PinsPath, readed, 0600)
+}
+
+func (t *TrustStore) Pin(server string, entity *openpgp.Entity) error {
+	pins, err := t.Pins()
+	if err != nil {
+		return err
+	}
+
+	pins[server] = Fingerprint(entity)
+	return t.savePins(pins)
+}
+
+func (t *TrustStore) Unpin(server string) error {
+	pins, err := t.Pins()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := pins[server]; !ok {
+		return fmt.Errorf("no key pinned for server %s", server)
+	}
+
+	delete(pins, server)
+	return t.savePins(pins)
+}
+
+// CheckPin pins the first signer for server on first use. When none of the
+// signers is the pinned key the user has to explicitly accept the change.
+func (t *TrustStore) CheckPin(server string, signers []*openpgp.Entity) error {
+	if len(signers) == 0 {
+		return fmt.Errorf("no signers to check against pinned key")
+	}
+
+	pins, err := t.Pins()
+	if err != nil {
+		return err
+	}
+
+	entity := signers[0]
+	pinned, ok := pins[server]
+	if !ok {
+		fmt.Printf("Pinning key %s for server %s (trust on first use)\n", Fingerprint(entity), server)
+		return t.Pin(server, entity)
+	}
+
+	for _, signer := range signers {
+		if pinned == Fingerprint(signer) {
+			return nil
+		}
+	}
+
+	var reply string
+	banner := strings.Repeat("@", 64)
+
+	fmt.Println(banner)
+	fmt.Println("@    WARNING: CASE SIGNING KEY FOR THIS SERVER HAS CHANGED!    @")
+	fmt.Println(banner)
+	fmt.Printf("Server: %s\n", server)
+	fmt.Printf("Pinned key:    %s\n", pinned)
+	for _, signer := range signers {
+		fmt.Printf("Signed by key: %s\n", Fingerprint(signer))
+	}
+	fmt.Println("Someone may be trying to run commands on this machine with a")
+	fmt.Println("configuration signed by an unexpected key.")
+	fmt.Printf("Type 'yes' to accept the new key and pin it for this server: ")
+	fmt.Scanf("%s", &reply)
+
+	if reply != "yes" {
+		return fmt.Errorf("case signed by %s but %s is pinned for %s", Fingerprint(entity), pinned, server)
+	}
+
+	return t.Pin(server, entity)
+}
@@ -1,11 +1,16 @@
 package core
 
 import (
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"regexp"
+	"strings"
 )
 
+const MaxFilenameLength = 255
+
 func CreateDirIfNotExists(base string, perms int) (string, error) {
 	if _, err := os.Stat(base); os.IsNotExist(err) {
 		err := os.MkdirAll(base, 0700)
@@ -97,3 +102,65 @@ func Contains(s []int, e int) bool {
 	}
 	return false
 }
+
+// SanitizeFilename accepts a plain file name, rejecting anything that could
+// resolve outside of the directory it is written to: empty names, "." and
+// "..", path separators, drive letters and control characters.
+func SanitizeFilename(name string) (string, error) {
+	if name == "" || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid filename: %q", name)
+	}
+
+	if len(name) > MaxFilenameLength {
+		return "", fmt.Errorf("filename longer than %d bytes", MaxFilenameLength)
+	}
+
+	if strings.ContainsAny(name, "/\\:") {
+		return "", fmt.Errorf("filename must not contain a path: %q", name)
+	}
+
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f {
+			return "", fmt.Errorf("filename contains control characters: %q", name)
+		}
+	}
+
+	return name, nil
+}
+
+// ContainedPath joins a sanitized name to base and checks that the result
+// is still inside base.
+func ContainedPath(base string, name string) (string, error) {
+	name, err := SanitizeFilename(name)
+	if err != nil {
+		return "", err
+	}
+
+	base, err = filepath.Abs(base)
+	if err != nil {
+		return "", err
+	}
+
+	target := filepath.Join(base, name)
+	if rel, err := filepath.Rel(base, target); err != nil || rel != name {
+		return "", fmt.Errorf("filename escapes %s: %q", base, name)
+	}
+
+	return target, nil
+}
+
+func FormatBytes(size int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+
+	if unit == 0 {
+		return fmt.Sprintf("%d %s", size, units[unit])
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
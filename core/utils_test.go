@@ -0,0 +1,88 @@
+package core
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var unsafeFilenames = []string{
+	"",
+	".",
+	"..",
+	"../../.ssh/authorized_keys",
+	"../report.tar.gz",
+	"logs/../../etc/passwd",
+	"/etc/passwd",
+	"/tmp",
+	`..\..\Windows\System32\drivers\etc\hosts`,
+	`\\server\share\file`,
+	`C:\Windows\win.ini`,
+	"C:report.txt",
+	"c:",
+	"report\x00.txt",
+	"report\n.txt",
+	"\x1b[31mreport",
+	"report\x7f",
+	strings.Repeat("a", MaxFilenameLength+1),
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	for _, name := range unsafeFilenames {
+		if got, err := SanitizeFilename(name); err == nil {
+			t.Errorf("%q: expected an error, got %q", name, got)
+		}
+	}
+
+	safe := []string{
+		"report.tar.gz",
+		"..report",
+		"report..",
+		".hidden",
+		"with spaces.txt",
+		"unicodé-報告.log",
+		strings.Repeat("a", MaxFilenameLength),
+	}
+
+	for _, name := range safe {
+		got, err := SanitizeFilename(name)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", name, err)
+		} else if got != name {
+			t.Errorf("%q: got %q", name, got)
+		}
+	}
+}
+
+func TestContainedPath(t *testing.T) {
+	base := filepath.Join("pull", "1234")
+
+	abs, err := filepath.Abs(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range unsafeFilenames {
+		if got, err := ContainedPath(base, name); err == nil {
+			t.Errorf("%q: expected an error, got %q", name, got)
+		}
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"report.tar.gz", filepath.Join(abs, "report.tar.gz")},
+		{"..report", filepath.Join(abs, "..report")},
+		{".hidden", filepath.Join(abs, ".hidden")},
+	}
+
+	for _, test := range tests {
+		got, err := ContainedPath(base, test.name)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", test.name, err)
+		} else if got != test.want {
+			t.Errorf("%q: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
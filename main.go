@@ -16,5 +16,14 @@ func main() {
 		new(commands.ShowCommand),
 		new(commands.CreateCommand),
 		new(commands.ServerCommand),
+		new(commands.TrustCommand),
+		new(commands.SignCommand),
+		new(commands.TokenCommand),
+		new(commands.ListCommand),
+		new(commands.CaseCommand),
+		new(commands.CommentCommand),
+		new(commands.CatCommand),
+		new(commands.SearchCommand),
+		new(commands.AuditCommand),
 	)
 }
@@ -0,0 +1,328 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/astaxie/beego/orm"
+	"github.com/emicklei/go-restful"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	AuditCaseCreate    = "case.create"
+	AuditCaseRead      = "case.read"
+	AuditCaseDelete    = "case.delete"
+	AuditCasePurge     = "case.purge"
+	AuditCaseHold      = "case.hold"
+	AuditCaseInvite    = "case.invite"
+	AuditFileUpload    = "file.upload"
+	AuditFileDownload  = "file.download"
+	AuditFileDelete    = "file.delete"
+	AuditFilePurge     = "file.purge"
+	AuditEntryRead     = "entry.read"
+	AuditReportSearch  = "report.search"
+	AuditTokenUse      = "token.use"
+	AuditTokenCreate   = "token.create"
+	AuditTokenRevoke   = "token.revoke"
+	AuditUserCreate    = "user.create"
+	AuditUserDelete    = "user.delete"
+	AuditUserRotateKey = "user.rotate-key"
+	AuditWebhookCreate = "webhook.create"
+	AuditWebhookDelete = "webhook.delete"
+
+	DefaultAuditLimit = 100
+	MaxAuditLimit     = 1000
+
+	// auditBatch is the number of events read at once when verifying.
+	auditBatch = 1000
+
+	// auditHeadId is the single row of the audit_head table.
+	auditHeadId = 1
+)
+
+// AuditEvent is an entry of the append-only audit log. Every event carries the
+// hash of the previous one, so altering or removing an event breaks the chain
+// from that point on. Cases are referenced by their identifier, which outlives
+// the case itself.
+type AuditEvent struct {
+	Id         int       `orm:"auto"`
+	Action     string    `orm:"size(32);index"`
+	Actor      string    `orm:"size(128);index"`
+	RemoteAddr string    `orm:"default();size(64)"`
+	Case       string    `orm:"default();size(36);index"`
+	FileId     int       `orm:"default(0)"`
+	Detail     string    `orm:"default();size(255)"`
+	Created    time.Time `orm:"type(datetime);index"`
+	PrevHash   string    `orm:"default();size(64)"`
+	Hash       string    `orm:"size(64)"`
+}
+
+// AuditHead holds the hash of the last audit event. Writers lock its row for
+// the rest of their transaction, which serializes the chain across servers
+// sharing the database.
+type AuditHead struct {
+	Id   int    `orm:"pk"`
+	Hash string `orm:"size(64)"`
+}
+
+type AuditResponse struct {
+	Events []*AuditEvent
+	Next   int `json:",omitempty"`
+}
+
+// AuditVerification is the result of checking the hash chain. Head is the
+// hash of the last event: recording it elsewhere also detects truncation.
+type AuditVerification struct {
+	Valid    bool
+	Events   int
+	Head     string
+	BrokenAt int    `json:",omitempty"`
+	Error    string `json:",omitempty"`
+}
+
+// ComputeHash returns the hash of the event chained to its previous hash.
+func (e *AuditEvent) ComputeHash() string {
+	encoded, _ := json.Marshal([]interface{}{
+		e.PrevHash, e.Created.Unix(), e.Action, e.Actor, e.RemoteAddr, e.Case, e.FileId, e.Detail,
+	})
+
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
+}
+
+// AppendAudit appends event to the audit log. It must run in a transaction
+// on o, so the event is committed or rolled back with the change it records.
+func AppendAudit(o orm.Ormer, event *AuditEvent) error {
+	// Updating the head takes its row lock before reading it.
+	locked, err := o.QueryTable("audit_head").Filter("Id", auditHeadId).Update(orm.Params{"Id": auditHeadId})
+	if err != nil {
+		return err
+	}
+	if locked == 0 {
+		return fmt.Errorf("the audit chain head is missing, run the migrations")
+	}
+
+	head := AuditHead{Id: auditHeadId}
+	if err := o.Read(&head); err != nil {
+		return err
+	}
+
+	event.Id = 0
+	event.PrevHash = head.Hash
+	event.Created = time.Unix(time.Now().Unix(), 0)
+	event.Actor = Truncate(event.Actor, 128)
+	event.RemoteAddr = Truncate(event.RemoteAddr, 64)
+	event.Detail = Truncate(event.Detail, 255)
+	event.Hash = event.ComputeHash()
+
+	if _, err := o.Insert(event); err != nil {
+		return err
+	}
+
+	head.Hash = event.Hash
+	_, err = o.Update(&head, "Hash")
+	return err
+}
+
+// RecordAudit appends event to the audit log in a transaction of its own.
+func RecordAudit(o orm.Ormer, event *AuditEvent) error {
+	return InTransaction(o, func() error {
+		return AppendAudit(o, event)
+	})
+}
+
+// RemoteAddr is the address the request came from, without its port.
+func RemoteAddr(request *restful.Request) string {
+	host, _, err := net.SplitHostPort(request.Request.RemoteAddr)
+	if err != nil {
+		return request.Request.RemoteAddr
+	}
+	return host
+}
+
+// NewAuditEvent returns the event of action by the caller of request on c,
+// which may be nil.
+func NewAuditEvent(request *restful.Request, action string, c *Case, fileId int, detail string) *AuditEvent {
+	event := &AuditEvent{
+		Action:     action,
+		Actor:      Uploader(request),
+		RemoteAddr: RemoteAddr(request),
+		FileId:     fileId,
+		Detail:     detail,
+	}
+
+	if c != nil {
+		event.Case = c.Uid
+	}
+	return event
+}
+
+// Audit records action by the caller of request on c, which may be nil. The
+// error is logged and returned, reads refuse to serve what was not audited.
+// Changes record their event with AppendAudit in their own transaction.
+func Audit(o orm.Ormer, request *restful.Request, action string, c *Case, fileId int, detail string) error {
+	event := NewAuditEvent(request, action, c, fileId, detail)
+
+	err := RecordAudit(o, event)
+	if err != nil {
+		log.Printf("cannot record %s audit event by %s: %s", action, event.Actor, err)
+	}
+	return err
+}
+
+// AuditTokenAccess records a request authorized by a case token.
+func AuditTokenAccess(o orm.Ormer, request *restful.Request, c *Case, secret string, action string) error {
+	detail := action
+
+	token := Token{Secret: HashSecret(secret)}
+	if err := o.Read(&token, "Secret"); err == nil {
+		detail = fmt.Sprintf("%s with token %d", action, token.Id)
+	}
+
+	return Audit(o, request, AuditTokenUse, c, 0, detail)
+}
+
+// VerifyAudit walks the whole audit log and checks its hash chain.
+func VerifyAudit(o orm.Ormer) (*AuditVerification, error) {
+	result := &AuditVerification{Valid: true}
+
+	lastId := 0
+	for {
+		var events []*AuditEvent
+		_, err := o.QueryTable("audit_event").Filter("Id__gt", lastId).OrderBy("Id").Limit(auditBatch).All(&events)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, event := range events {
+			switch {
+			case event.PrevHash != result.Head:
+				result.Error = "previous hash does not match, an event was removed or reordered"
+			case event.ComputeHash() != event.Hash:
+				result.Error = "hash does not match, the event was modified"
+			}
+
+			if result.Error != "" {
+				result.Valid = false
+				result.BrokenAt = event.Id
+				return result, nil
+			}
+
+			result.Head = event.Hash
+			result.Events++
+			lastId = event.Id
+		}
+
+		if len(events) < auditBatch {
+			return result, nil
+		}
+	}
+}
+
+type AuditHandler struct{}
+
+func (handler *AuditHandler) List(request *restful.Request, response *restful.Response) {
+	qs := orm.NewOrm().QueryTable("audit_event")
+
+	filters := map[string]string{
+		"Case":   request.QueryParameter("case"),
+		"Actor":  request.QueryParameter("actor"),
+		"Action": request.QueryParameter("action"),
+	}
+
+	for field, value := range filters {
+		if value != "" {
+			qs = qs.Filter(field, value)
+		}
+	}
+
+	for param, filter := range map[string]string{"since": "Created__gte", "until": "Created__lt"} {
+		value := request.QueryParameter(param)
+		if value == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			response.WriteErrorString(http.StatusBadRequest, fmt.Sprintf("invalid %s time, expected RFC 3339: %s", param, value))
+			return
+		}
+		qs = qs.Filter(filter, t)
+	}
+
+	if before := request.QueryParameter("before"); before != "" {
+		id, err := strconv.Atoi(before)
+		if err != nil {
+			response.WriteErrorString(http.StatusBadRequest, "invalid before event id")
+			return
+		}
+		qs = qs.Filter("Id__lt", id)
+	}
+
+	limit := DefaultAuditLimit
+	if value := request.QueryParameter("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > MaxAuditLimit {
+			response.WriteErrorString(http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", MaxAuditLimit))
+			return
+		}
+	}
+
+	result := &AuditResponse{Events: []*AuditEvent{}}
+	if _, err := qs.OrderBy("-Id").Limit(limit + 1).All(&result.Events); err != nil {
+		response.WriteErrorString(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if len(result.Events) > limit {
+		result.Events = result.Events[:limit]
+		result.Next = result.Events[limit-1].Id
+	}
+
+	response.WriteEntity(result)
+}
+
+func (handler *AuditHandler) Verify(request *restful.Request, response *restful.Response) {
+	result, err := VerifyAudit(orm.NewOrm())
+	if err != nil {
+		response.WriteErrorString(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.WriteEntity(result)
+}
+
+func AuditWebService() *restful.WebService {
+	handler := &AuditHandler{}
+
+	ws := new(restful.WebService)
+	ws.Path("/1/audit").
+		Doc("Read the audit log of server actions").
+		Produces(restful.MIME_JSON)
+
+	ws.Route(ws.GET("").Filter(Authorize(ActionAdmin)).To(handler.List).
+		Doc("list audit events, newest first").
+		Operation("listAuditEvents").
+		Param(ws.QueryParameter("case", "only events of this case")).
+		Param(ws.QueryParameter("actor", "only events by this user, case token or anonymous")).
+		Param(ws.QueryParameter("action", "only events of this action")).
+		Param(ws.QueryParameter("since", "only events at or after this RFC 3339 time")).
+		Param(ws.QueryParameter("until", "only events before this RFC 3339 time")).
+		Param(ws.QueryParameter("before", "only events older than this event id").DataType("int")).
+		Param(ws.QueryParameter("limit", "number of events").DataType("int")).
+		Writes(AuditResponse{}))
+
+	ws.Route(ws.GET("/verify").Filter(Authorize(ActionAdmin)).To(handler.Verify).
+		Doc("check the hash chain of the audit log").
+		Operation("verifyAuditLog").
+		Writes(AuditVerification{}))
+
+	return ws
+}
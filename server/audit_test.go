@@ -0,0 +1,107 @@
+package server
+
+import (
+	"fmt"
+	"github.com/astaxie/beego/orm"
+	"github.com/emicklei/go-restful"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAuditChain(t *testing.T) {
+	o := openTestDatabase(t)
+
+	// Other tests record events through the handlers, start a new chain.
+	reset := func() {
+		o.QueryTable("audit_event").Filter("Id__gt", 0).Delete()
+		o.QueryTable("audit_head").Filter("Id", auditHeadId).Update(orm.Params{"Hash": ""})
+	}
+	reset()
+	defer reset()
+
+	for i := 0; i < 3; i++ {
+		event := &AuditEvent{Action: AuditCaseRead, Actor: "jane", Detail: fmt.Sprintf("read %d", i)}
+		if err := RecordAudit(o, event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	long := &AuditEvent{Action: AuditCaseRead, Actor: strings.Repeat("a", 300)}
+	if err := RecordAudit(o, long); err != nil {
+		t.Fatal(err)
+	}
+	if len(long.Actor) != 128 {
+		t.Errorf("actor not truncated: %d characters", len(long.Actor))
+	}
+
+	// An event recorded with a change that fails is rolled back with it.
+	failed := fmt.Errorf("change failed")
+	err := InTransaction(o, func() error {
+		if err := AppendAudit(o, &AuditEvent{Action: AuditCaseDelete, Actor: "jane"}); err != nil {
+			return err
+		}
+		return failed
+	})
+	if err != failed {
+		t.Fatalf("got %v, want %v", err, failed)
+	}
+
+	result, err := VerifyAudit(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.Valid || result.Events != 4 || result.Head != long.Hash {
+		t.Errorf("unexpected verification: %+v", result)
+	}
+
+	head := AuditHead{Id: auditHeadId}
+	if err := o.Read(&head); err != nil {
+		t.Fatal(err)
+	}
+	if head.Hash != long.Hash {
+		t.Errorf("chain head %s, want %s", head.Hash, long.Hash)
+	}
+}
+
+func TestInviteAudited(t *testing.T) {
+	o := openTestDatabase(t)
+
+	rows := &testRows{t: t, o: o}
+	defer rows.remove()
+
+	engineer := rows.user("invite-engineer", RoleEngineer, "")
+	customer := rows.user("invite-customer", RoleCustomer, "")
+	c := rows.newCase(engineer, "")
+	defer o.QueryTable("invitation").Filter("Case", c.Id).Delete()
+
+	httpRequest, _ := http.NewRequest("POST", "/1/case/"+c.Uid+"/invite", strings.NewReader(`{"User": "invite-customer"}`))
+	httpRequest.Header.Set("Content-Type", restful.MIME_JSON)
+
+	request := restful.NewRequest(httpRequest)
+	request.SetAttribute(UserAttribute, engineer)
+	request.SetAttribute(CaseAttribute, c)
+
+	recorder := httptest.NewRecorder()
+	(&CaseHandler{}).Invite(request, restful.NewResponse(recorder))
+
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("got status %d: %s", recorder.Code, recorder.Body)
+	}
+
+	if !IsInvited(o, c, customer) {
+		t.Error("customer not invited")
+	}
+
+	var event AuditEvent
+	err := o.QueryTable("audit_event").Filter("Action", AuditCaseInvite).Filter("Case", c.Uid).One(&event)
+	if err != nil {
+		t.Fatalf("invitation not audited: %s", err)
+	}
+
+	if event.Actor != engineer.Name || !strings.Contains(event.Detail, customer.Name) {
+		t.Errorf("unexpected audit event: %+v", event)
+	}
+}
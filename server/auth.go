@@ -0,0 +1,448 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/astaxie/beego/orm"
+	"github.com/emicklei/go-restful"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	RoleAdmin    = "admin"
+	RoleEngineer = "engineer"
+	RoleCustomer = "customer"
+
+	ActionCreate   = "create"
+	ActionList     = "list"
+	ActionRead     = "read"
+	ActionUpload   = "upload"
+	ActionDownload = "download"
+	ActionManage   = "manage"
+	ActionComment  = "comment"
+	ActionAdmin    = "admin"
+
+	UserAttribute = "mayday.user"
+	CaseAttribute = "mayday.case"
+)
+
+type User struct {
+	Id      int       `orm:"auto"`
+	Name    string    `orm:"unique;size(128)"`
+	Role    string    `orm:"size(16)"`
+	Team    string    `orm:"default();size(128)"`
+	ApiKey  string    `orm:"unique;size(64)" json:"-"`
+	Created time.Time `orm:"auto_now_add;type(datetime)"`
+}
+
+type Invitation struct {
+	Id      int       `orm:"auto"`
+	Case    *Case     `orm:"rel(fk)"`
+	User    *User     `orm:"rel(fk)"`
+	Created time.Time `orm:"auto_now_add;type(datetime)"`
+}
+
+type UserResponse struct {
+	User   *User
+	ApiKey string
+}
+
+type InvitationRequest struct {
+	User string
+}
+
+type UserHandler struct{}
+
+func ValidRole(role string) bool {
+	return role == RoleAdmin || role == RoleEngineer || role == RoleCustomer
+}
+
+func HashSecret(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func NewSecret() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(key), nil
+}
+
+// Authenticate returns the user owning the given API key, or nil when the
+// token is not an API key (it may still be a case token).
+func Authenticate(o orm.Ormer, token string) (*User, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	user := User{ApiKey: HashSecret(token)}
+	err := o.Read(&user, "ApiKey")
+	if err == orm.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func CurrentUser(request *restful.Request) *User {
+	user, _ := request.Attribute(UserAttribute).(*User)
+	return user
+}
+
+func CurrentCase(request *restful.Request) *Case {
+	c, _ := request.Attribute(CaseAttribute).(*Case)
+	return c
+}
+
+func LoadCase(o orm.Ormer, caseId string) (*Case, error) {
+	if caseId == "" {
+		return nil, fmt.Errorf("invalid provided id")
+	}
+
+	c := Case{Uid: caseId}
+	if err := o.Read(&c, "Uid"); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+func IsInvited(o orm.Ormer, c *Case, user *User) bool {
+	return o.QueryTable("invitation").Filter("Case", c.Id).Filter("User", user.Id).Exist()
+}
+
+func CanDo(user *User, action string) bool {
+	if user == nil {
+		return false
+	}
+
+	switch action {
+	case ActionAdmin:
+		return user.Role == RoleAdmin
+	case ActionCreate:
+		return user.Role == RoleAdmin || user.Role == RoleEngineer
+	case ActionList:
+		return true
+	}
+
+	return false
+}
+
+func CanAccess(o orm.Ormer, user *User, token string, c *Case, action string) bool {
+	if user != nil {
+		switch user.Role {
+		case RoleAdmin:
+			return true
+		case RoleEngineer:
+			if action == ActionAdmin {
+				return false
+			}
+			return (user.Team != "" && c.Team == user.Team) || (c.Owner != nil && c.Owner.Id == user.Id)
+		case RoleCustomer:
+			return (action == ActionRead || action == ActionUpload || action == ActionComment) && IsInvited(o, c, user)
+		}
+		return false
+	}
+
+	if action != ActionRead && action != ActionUpload && action != ActionDownload {
+		return false
+	}
+
+	if !c.IsPrivate {
+		return true
+	}
+
+	return AuthorizeToken(o, c, token, action)
+}
+
+func deny(response *restful.Response, user *User) {
+	if user == nil {
+		response.WriteErrorString(http.StatusUnauthorized, "authentication required")
+	} else {
+		response.WriteErrorString(http.StatusForbidden, "access denied")
+	}
+}
+
+// Authorize returns the filter of a route needing action: it identifies the
+// caller from the bearer token, loads the case addressed by the request and
+// enforces the role based access rules before the handler runs. Every route
+// declares its action this way.
+func Authorize(action string) restful.FilterFunction {
+	return func(request *restful.Request, response *restful.Response, chain *restful.FilterChain) {
+		o := orm.NewOrm()
+		token := RequestToken(request)
+
+		user, err := Authenticate(o, token)
+		if err != nil {
+			response.WriteErrorString(http.StatusInternalServerError, "cannot authenticate request")
+			return
+		}
+
+		if user != nil {
+			request.SetAttribute(UserAttribute, user)
+		}
+
+		caseId := request.PathParameter("case-id")
+
+		if caseId == "" {
+			if !CanDo(user, action) {
+				deny(response, user)
+				return
+			}
+
+			chain.ProcessFilter(request, response)
+			return
+		}
+
+		c, err := LoadCase(o, caseId)
+		if err != nil {
+			response.WriteErrorString(http.StatusNotFound, err.Error())
+			return
+		}
+
+		if !CanAccess(o, user, token, c, action) {
+			deny(response, user)
+			return
+		}
+
+		if user == nil && c.IsPrivate {
+			if err := AuditTokenAccess(o, request, c, token, action); err != nil {
+				response.WriteErrorString(http.StatusInternalServerError, "cannot record the token access")
+				return
+			}
+		}
+
+		request.SetAttribute(CaseAttribute, c)
+		chain.ProcessFilter(request, response)
+	}
+}
+
+func CreateUser(o orm.Ormer, name string, role string, team string) (*UserResponse, error) {
+	if name == "" || !ValidRole(role) {
+		return nil, fmt.Errorf("a user needs a name and one of the roles: %s, %s, %s",
+			RoleAdmin, RoleEngineer, RoleCustomer)
+	}
+
+	key, err := NewSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	user := &User{Name: name, Role: role, Team: team, ApiKey: HashSecret(key)}
+	if _, err := o.Insert(user); err != nil {
+		return nil, err
+	}
+
+	return &UserResponse{User: user, ApiKey: key}, nil
+}
+
+func userDetail(user *User) string {
+	return fmt.Sprintf("user %d %s as %s", user.Id, user.Name, user.Role)
+}
+
+// BootstrapAdmin creates the first admin user of an empty server and returns
+// its API key.
+func BootstrapAdmin(name string) (string, error) {
+	o := orm.NewOrm()
+
+	if o.QueryTable("user").Filter("Role", RoleAdmin).Exist() {
+		return "", fmt.Errorf("an admin user already exists")
+	}
+
+	var created *UserResponse
+
+	err := InTransaction(o, func() error {
+		var err error
+		if created, err = CreateUser(o, name, RoleAdmin, ""); err != nil {
+			return err
+		}
+
+		return AppendAudit(o, &AuditEvent{Action: AuditUserCreate, Actor: "bootstrap", Detail: userDetail(created.User)})
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return created.ApiKey, nil
+}
+
+func (handler *UserHandler) Create(request *restful.Request, response *restful.Response) {
+	u := new(User)
+	err := request.ReadEntity(u)
+
+	if err != nil {
+		response.WriteErrorString(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	o := orm.NewOrm()
+
+	var created *UserResponse
+	var auditErr error
+
+	err = InTransaction(o, func() error {
+		var err error
+		if created, err = CreateUser(o, u.Name, u.Role, u.Team); err != nil {
+			return err
+		}
+
+		auditErr = AppendAudit(o, NewAuditEvent(request, AuditUserCreate, nil, 0, userDetail(created.User)))
+		return auditErr
+	})
+	if auditErr != nil {
+		response.WriteErrorString(http.StatusInternalServerError, auditErr.Error())
+		return
+	} else if err != nil {
+		response.WriteErrorString(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	response.WriteHeader(http.StatusCreated)
+	response.WriteEntity(created)
+}
+
+func (handler *UserHandler) List(request *restful.Request, response *restful.Response) {
+	var users []*User
+
+	if _, err := orm.NewOrm().QueryTable("user").OrderBy("Id").All(&users); err != nil {
+		response.WriteErrorString(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.WriteEntity(users)
+}
+
+func (handler *UserHandler) Delete(request *restful.Request, response *restful.Response) {
+	id, err := strconv.Atoi(request.PathParameter("user-id"))
+	if err != nil {
+		response.WriteErrorString(http.StatusBadRequest, "invalid provided user id")
+		return
+	}
+
+	o := orm.NewOrm()
+
+	err = InTransaction(o, func() error {
+		if _, err := o.Delete(&User{Id: id}); err != nil {
+			return err
+		}
+		return AppendAudit(o, NewAuditEvent(request, AuditUserDelete, nil, 0, fmt.Sprintf("user %d", id)))
+	})
+	if err != nil {
+		response.WriteErrorString(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.WriteHeader(http.StatusNoContent)
+}
+
+func (handler *UserHandler) RotateKey(request *restful.Request, response *restful.Response) {
+	id, err := strconv.Atoi(request.PathParameter("user-id"))
+	if err != nil {
+		response.WriteErrorString(http.StatusBadRequest, "invalid provided user id")
+		return
+	}
+
+	o := orm.NewOrm()
+	user := User{Id: id}
+	if err := o.Read(&user); err != nil {
+		response.WriteErrorString(http.StatusNotFound, err.Error())
+		return
+	}
+
+	key, err := NewSecret()
+	if err != nil {
+		response.WriteErrorString(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	user.ApiKey = HashSecret(key)
+
+	err = InTransaction(o, func() error {
+		if _, err := o.Update(&user, "ApiKey"); err != nil {
+			return err
+		}
+		return AppendAudit(o, NewAuditEvent(request, AuditUserRotateKey, nil, 0, fmt.Sprintf("user %d", user.Id)))
+	})
+	if err != nil {
+		response.WriteErrorString(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.WriteEntity(UserResponse{User: &user, ApiKey: key})
+}
+
+func (handler *CaseHandler) Invite(request *restful.Request, response *restful.Response) {
+	c := CurrentCase(request)
+
+	i := new(InvitationRequest)
+	if err := request.ReadEntity(i); err != nil {
+		response.WriteErrorString(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	o := orm.NewOrm()
+	user := User{Name: i.User}
+	if err := o.Read(&user, "Name"); err != nil {
+		response.WriteErrorString(http.StatusNotFound, "unknown user")
+		return
+	}
+
+	if !IsInvited(o, c, &user) {
+		err := InTransaction(o, func() error {
+			if _, err := o.Insert(&Invitation{Case: c, User: &user}); err != nil {
+				return err
+			}
+			return AppendAudit(o, NewAuditEvent(request, AuditCaseInvite, c, 0, userDetail(&user)))
+		})
+		if err != nil {
+			response.WriteErrorString(http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	response.WriteHeader(http.StatusCreated)
+	response.WriteEntity(user)
+}
+
+func UserWebService() *restful.WebService {
+	handler := &UserHandler{}
+
+	ws := new(restful.WebService)
+	ws.Path("/1/user").
+		Doc("Manage users and API keys").
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON)
+
+	ws.Route(ws.GET("").Filter(Authorize(ActionAdmin)).To(handler.List).
+		Doc("list users").
+		Operation("listUsers").
+		Writes([]User{}))
+
+	ws.Route(ws.POST("").Filter(Authorize(ActionAdmin)).To(handler.Create).
+		Doc("create a user and its API key").
+		Operation("createUser").
+		Reads(User{}).
+		Writes(UserResponse{}))
+
+	ws.Route(ws.DELETE("/{user-id}").Filter(Authorize(ActionAdmin)).To(handler.Delete).
+		Doc("delete a user").
+		Operation("deleteUser").
+		Param(ws.PathParameter("user-id", "user identifier").DataType("int")))
+
+	ws.Route(ws.POST("/{user-id}/key").Filter(Authorize(ActionAdmin)).To(handler.RotateKey).
+		Doc("issue a new API key for a user").
+		Operation("rotateUserKey").
+		Param(ws.PathParameter("user-id", "user identifier").DataType("int")).
+		Writes(UserResponse{}))
+
+	return ws
+}
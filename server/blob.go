@@ -0,0 +1,137 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/astaxie/beego/orm"
+	"log"
+	"path"
+	"sync"
+	"time"
+)
+
+// Blob is a stored content, addressed by its SHA-256 digest and shared by
+// every File with the same contents. Indexed is set once its archive entries
+// and words were recorded, or once it was found not to be an archive.
+type Blob struct {
+	Id       int       `orm:"auto"`
+	Digest   string    `orm:"unique;size(64)"`
+	Size     int64     `orm:"default(0)"`
+	RefCount int       `orm:"default(0)"`
+	Indexed  bool      `orm:"default(false)"`
+	Created  time.Time `orm:"auto_now_add;type(datetime)"`
+}
+
+// blobLock serializes reference count changes with the storage writes and
+// deletes they imply.
+var blobLock sync.Mutex
+
+func Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func BlobKey(digest string) string {
+	return path.Join("blobs", digest[:2], digest)
+}
+
+// StoreBlob stores data once and returns its blob with one more reference.
+func StoreBlob(o orm.Ormer, storage Storage, data []byte) (*Blob, error) {
+	blobLock.Lock()
+	defer blobLock.Unlock()
+
+	blob := &Blob{Digest: Digest(data)}
+	err := o.Read(blob, "Digest")
+	if err == nil {
+		updated, err := o.QueryTable("blob").Filter("Id", blob.Id).
+			Update(orm.Params{"RefCount": orm.ColValue(orm.Col_Add, 1)})
+		if err != nil {
+			return nil, err
+		}
+
+		// Zero when a deletion released it meanwhile, store it again.
+		if updated == 1 {
+			blob.RefCount++
+			return blob, nil
+		}
+		blob = &Blob{Digest: blob.Digest}
+	} else if err != orm.ErrNoRows {
+		return nil, err
+	}
+
+	if err := storage.Put(BlobKey(blob.Digest), bytes.NewReader(data), int64(len(data))); err != nil {
+		return nil, err
+	}
+
+	blob.Size = int64(len(data))
+	blob.RefCount = 1
+	if _, err := o.Insert(blob); err != nil {
+		return nil, err
+	}
+
+	return blob, nil
+}
+
+// ReleaseBlob drops one reference to blob and removes it from the database
+// when no File points to it anymore, telling whether it did. It only touches
+// the database so that it can run in a transaction: the object is removed
+// from the storage by RemoveBlobObjects once committed.
+func ReleaseBlob(o orm.Ormer, blob *Blob) (bool, error) {
+	_, err := o.QueryTable("blob").Filter("Id", blob.Id).
+		Update(orm.Params{"RefCount": orm.ColValue(orm.Col_Minus, 1)})
+	if err != nil {
+		return false, err
+	}
+
+	if err := o.Read(blob); err != nil {
+		return false, err
+	}
+
+	if blob.RefCount > 0 {
+		return false, nil
+	}
+
+	if _, err := o.QueryTable("posting").Filter("Blob", blob.Id).Delete(); err != nil {
+		return false, err
+	}
+
+	if _, err := o.QueryTable("entry").Filter("Blob", blob.Id).Delete(); err != nil {
+		return false, err
+	}
+
+	if _, err := o.Delete(blob); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// RemoveBlobObjects deletes the objects of released blobs from the storage,
+// unless an upload stored the same content again meanwhile.
+func RemoveBlobObjects(o orm.Ormer, storage Storage, blobs []*Blob) {
+	blobLock.Lock()
+	defer blobLock.Unlock()
+
+	for _, blob := range blobs {
+		if o.QueryTable("blob").Filter("Digest", blob.Digest).Exist() {
+			continue
+		}
+
+		if err := storage.Delete(BlobKey(blob.Digest)); err != nil {
+			log.Printf("cannot remove blob %s from the storage: %s", blob.Digest, err)
+		}
+	}
+}
+
+// DropBlob releases one reference to blob and removes its object when it was
+// the last one.
+func DropBlob(o orm.Ormer, storage Storage, blob *Blob) error {
+	released, err := ReleaseBlob(o, blob)
+	if err != nil || !released {
+		return err
+	}
+
+	RemoveBlobObjects(o, storage, []*Blob{blob})
+	return nil
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"fmt"
+	"github.com/astaxie/beego/orm"
+	"github.com/emicklei/go-restful"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	MaxCommentLength = 64 * 1024
+	MaxCommentPath   = 1024
+)
+
+var ErrPrivateComment = fmt.Errorf("customers cannot post private notes")
+
+// Comment is a markdown note on a case, optionally pointing at a file of the
+// case and a path inside that report. Private comments are notes between
+// engineers, never shown to customers.
+type Comment struct {
+	Id      int       `orm:"auto"`
+	Case    *Case     `orm:"rel(fk)" json:"-"`
+	Author  string    `orm:"size(128)"`
+	Body    string    `orm:"type(text)"`
+	FileId  int       `orm:"default(0)"`
+	Path    string    `orm:"default();size(1024)"`
+	Private bool      `orm:"default(false)"`
+	Created time.Time `orm:"auto_now_add;type(datetime)"`
+}
+
+type CommentRequest struct {
+	Body    string
+	FileId  int
+	Path    string
+	Private bool `json:",omitempty"`
+}
+
+// SeesPrivateComments tells whether user may read and write private notes.
+func SeesPrivateComments(user *User) bool {
+	return user != nil && (user.Role == RoleAdmin || user.Role == RoleEngineer)
+}
+
+type CommentHandler struct{}
+
+func NewComment(o orm.Ormer, c *Case, author *User, r *CommentRequest) (*Comment, error) {
+	if r.Body == "" {
+		return nil, fmt.Errorf("a comment needs a body")
+	}
+
+	if len(r.Body) > MaxCommentLength {
+		return nil, fmt.Errorf("comment longer than %d bytes", MaxCommentLength)
+	}
+
+	if len(r.Path) > MaxCommentPath {
+		return nil, fmt.Errorf("comment path longer than %d bytes", MaxCommentPath)
+	}
+
+	if r.Private && !SeesPrivateComments(author) {
+		return nil, ErrPrivateComment
+	}
+
+	if r.Path != "" && r.FileId == 0 {
+		return nil, fmt.Errorf("a path needs the file id of the report it belongs to")
+	}
+
+	if r.FileId != 0 && !o.QueryTable("file").Filter("Id", r.FileId).Filter("Case", c.Id).Exist() {
+		return nil, fmt.Errorf("unknown file id for this case: %d", r.FileId)
+	}
+
+	comment := &Comment{
+		Case:    c,
+		Author:  author.Name,
+		Body:    r.Body,
+		FileId:  r.FileId,
+		Path:    r.Path,
+		Private: r.Private,
+	}
+
+	if _, err := o.Insert(comment); err != nil {
+		return nil, err
+	}
+
+	return comment, nil
+}
+
+func (handler *CommentHandler) Create(request *restful.Request, response *restful.Response) {
+	r := new(CommentRequest)
+	if err := request.ReadEntity(r); err != nil {
+		response.WriteErrorString(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	comment, err := NewComment(orm.NewOrm(), CurrentCase(request), CurrentUser(request), r)
+	if err == ErrPrivateComment {
+		response.WriteErrorString(http.StatusForbidden, err.Error())
+		return
+	} else if err != nil {
+		response.WriteErrorString(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	response.WriteHeader(http.StatusCreated)
+	response.WriteEntity(comment)
+}
+
+func (handler *CommentHandler) List(request *restful.Request, response *restful.Response) {
+	var comments []*Comment
+
+	qs := orm.NewOrm().QueryTable("comment").Filter("Case", CurrentCase(request).Id)
+	if value := request.QueryParameter("file-id"); value != "" {
+		fileId, err := strconv.Atoi(value)
+		if err != nil {
+			response.WriteErrorString(http.StatusBadRequest, "invalid file id: "+value)
+			return
+		}
+		qs = qs.Filter("FileId", fileId)
+	}
+
+	if !SeesPrivateComments(CurrentUser(request)) {
+		qs = qs.Filter("Private", false)
+	}
+
+	if _, err := qs.OrderBy("Id").All(&comments); err != nil {
+		response.WriteErrorString(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.WriteEntity(comments)
+}
+
+func AddCommentRoutes(ws *restful.WebService) {
+	handler := &CommentHandler{}
+
+	ws.Route(ws.POST("/{case-id}/comments").Filter(Authorize(ActionComment)).To(handler.Create).
+		Doc("comment on a case").
+		Operation("createComment").
+		Param(ws.PathParameter("case-id", "case identifier").DataType("string")).
+		Reads(CommentRequest{}).
+		Writes(Comment{}))
+
+	ws.Route(ws.GET("/{case-id}/comments").Filter(Authorize(ActionComment)).To(handler.List).
+		Doc("list the comments of a case, private notes only for engineers").
+		Operation("listComments").
+		Param(ws.PathParameter("case-id", "case identifier").DataType("string")).
+		Param(ws.QueryParameter("file-id", "only comments about this file").DataType("int")).
+		Writes([]Comment{}))
+}
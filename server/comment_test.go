@@ -0,0 +1,29 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestPrivateComments(t *testing.T) {
+	tests := []struct {
+		user *User
+		sees bool
+	}{
+		{&User{Name: "root", Role: RoleAdmin}, true},
+		{&User{Name: "jane", Role: RoleEngineer}, true},
+		{&User{Name: "acme", Role: RoleCustomer}, false},
+		{nil, false},
+	}
+
+	for _, test := range tests {
+		if got := SeesPrivateComments(test.user); got != test.sees {
+			t.Errorf("%+v: got %t, want %t", test.user, got, test.sees)
+		}
+	}
+
+	customer := &User{Name: "acme", Role: RoleCustomer}
+	_, err := NewComment(nil, &Case{Id: 1}, customer, &CommentRequest{Body: "note", Private: true})
+	if err != ErrPrivateComment {
+		t.Errorf("customer posting a private note: got %v, want %v", err, ErrPrivateComment)
+	}
+}
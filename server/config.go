@@ -0,0 +1,138 @@
+package server
+
+import (
+	"fmt"
+	"github.com/astaxie/beego/orm"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	goyaml "gopkg.in/yaml.v1"
+	"io/ioutil"
+	"mayday/core"
+	"path"
+	"sync"
+)
+
+const (
+	DefaultBind       = "0.0.0.0"
+	DefaultDriver     = "sqlite3"
+	DefaultDatabase   = "server.db"
+	DefaultMaxIdle    = 30
+	DefaultDataSource = "default"
+)
+
+var registerModels sync.Once
+
+// Config is the mayday server configuration, read from a YAML file and
+// overridden by the `mayday server` flags.
+type Config struct {
+	Bind      string          `yaml:"bind"`
+	Port      int             `yaml:"port"`
+	Storage   StorageConfig   `yaml:"storage"`
+	Database  DatabaseConfig  `yaml:"database"`
+	Retention RetentionConfig `yaml:"retention"`
+	Quota     QuotaConfig     `yaml:"quota"`
+}
+
+type DatabaseConfig struct {
+	Driver  string `yaml:"driver"`
+	DSN     string `yaml:"dsn"`
+	MaxIdle int    `yaml:"max_idle"`
+}
+
+func LoadConfig(configPath string) (*Config, error) {
+	config := &Config{}
+
+	if configPath == "" {
+		return config, nil
+	}
+
+	readed, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read server configuration: %s", err)
+	}
+
+	if err := goyaml.Unmarshal(readed, config); err != nil {
+		return nil, fmt.Errorf("cannot read server configuration: %s", err)
+	}
+
+	return config, nil
+}
+
+// SetDefaults fills every unset option. The default database is a SQLite
+// file under the mayday directory, so data survives reboots.
+func (c *Config) SetDefaults(env core.Environment) error {
+	if c.Bind == "" {
+		c.Bind = DefaultBind
+	}
+
+	if c.Port == 0 {
+		c.Port = DefaultPort
+	}
+
+	if c.Storage.Backend == "" {
+		c.Storage.Backend = StorageLocal
+	}
+
+	if c.Storage.Backend == StorageLocal {
+		if c.Storage.Path == "" {
+			storage, err := env.GetDefaultStoragePath()
+			if err != nil {
+				return err
+			}
+			c.Storage.Path = storage
+		} else if _, err := core.CreateDirIfNotExists(c.Storage.Path, 0700); err != nil {
+			return err
+		}
+	}
+
+	if c.Quota.MaxUploadSize == 0 {
+		c.Quota.MaxUploadSize = DefaultMaxUploadSize
+	}
+
+	if c.Database.Driver == "" {
+		c.Database.Driver = DefaultDriver
+	}
+
+	if c.Database.MaxIdle == 0 {
+		c.Database.MaxIdle = DefaultMaxIdle
+	}
+
+	if c.Database.DSN == "" {
+		if c.Database.Driver != DefaultDriver {
+			return fmt.Errorf("database driver %s requires a dsn", c.Database.Driver)
+		}
+
+		base, err := env.GetDefaultDirectory()
+		if err != nil {
+			return err
+		}
+		c.Database.DSN = path.Join(base, DefaultDatabase)
+	}
+
+	return nil
+}
+
+func ValidDriver(driver string) bool {
+	return driver == "sqlite3" || driver == "postgres" || driver == "mysql"
+}
+
+// SetupDatabase registers the models and the configured database. The
+// schema is managed by the migrations, see NewMigrator.
+func SetupDatabase(config *DatabaseConfig) error {
+	if !ValidDriver(config.Driver) {
+		return fmt.Errorf("unsupported database driver: %s", config.Driver)
+	}
+
+	registerModels.Do(func() {
+		orm.RegisterModel(new(SchemaMigration), new(Case), new(File), new(User), new(Invitation), new(Token), new(Blob),
+			new(CaseTransition), new(Comment), new(Entry), new(Posting), new(Webhook), new(WebhookDelivery),
+			new(AuditEvent), new(AuditHead))
+	})
+
+	if err := orm.RegisterDataBase(DefaultDataSource, config.Driver, config.DSN, config.MaxIdle); err != nil {
+		return fmt.Errorf("cannot open %s database: %s", config.Driver, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"code.google.com/p/go-uuid/uuid"
+	"github.com/astaxie/beego/orm"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+var (
+	testDatabase    sync.Once
+	testDatabaseErr error
+)
+
+// openTestDatabase sets up a migrated SQLite database shared by the tests of
+// the package. Tests clean up the rows they create.
+func openTestDatabase(t *testing.T) orm.Ormer {
+	testDatabase.Do(func() {
+		dir, err := ioutil.TempDir("", "mayday-server")
+		if err != nil {
+			testDatabaseErr = err
+			return
+		}
+
+		config := &Config{
+			Database: DatabaseConfig{Driver: "sqlite3", DSN: filepath.Join(dir, "server.db"), MaxIdle: 1},
+			Storage:  StorageConfig{Backend: StorageLocal, Path: filepath.Join(dir, "storage")},
+		}
+
+		if testDatabaseErr = SetupDatabase(&config.Database); testDatabaseErr != nil {
+			return
+		}
+
+		m, err := NewMigrator(config)
+		if err != nil {
+			testDatabaseErr = err
+			return
+		}
+
+		_, testDatabaseErr = m.Up()
+	})
+
+	if testDatabaseErr != nil {
+		t.Fatalf("cannot set up the test database: %s", testDatabaseErr)
+	}
+
+	return orm.NewOrm()
+}
+
+// testRows inserts the rows of a test and removes them, newest first.
+type testRows struct {
+	t    *testing.T
+	o    orm.Ormer
+	rows []interface{}
+}
+
+func (r *testRows) insert(row interface{}) {
+	if _, err := r.o.Insert(row); err != nil {
+		r.t.Fatal(err)
+	}
+	r.rows = append(r.rows, row)
+}
+
+func (r *testRows) user(name string, role string, team string) *User {
+	user := &User{Name: name, Role: role, Team: team, ApiKey: HashSecret(name)}
+	r.insert(user)
+	return user
+}
+
+func (r *testRows) newCase(owner *User, team string) *Case {
+	c := &Case{Uid: uuid.New(), Owner: owner, Team: team, Status: StatusOpen}
+	r.insert(c)
+	return c
+}
+
+func (r *testRows) invite(c *Case, user *User) {
+	r.insert(&Invitation{Case: c, User: user})
+}
+
+func (r *testRows) remove() {
+	for i := len(r.rows) - 1; i >= 0; i-- {
+		if _, err := r.o.Delete(r.rows[i]); err != nil {
+			r.t.Errorf("cannot remove test row %+v: %s", r.rows[i], err)
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/astaxie/beego/orm"
+	"github.com/emicklei/go-restful"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MaxArchiveEntries = 100000
+
+	// IndexPoll is how often the indexer looks for blobs left unindexed, by
+	// a restart or a failure.
+	IndexPoll  = time.Minute
+	indexBatch = 20
+)
+
+var ErrEntryNotFound = fmt.Errorf("no such entry in the report")
+
+// errIndexed tells that another indexer recorded the blob first.
+var errIndexed = fmt.Errorf("blob already indexed")
+
+// indexWake tells the indexer that new blobs were uploaded.
+var indexWake = make(chan bool, 1)
+
+// Entry is a member of an uploaded tar or tar.gz report. Entries belong to
+// the blob, so identical reports are indexed once.
+type Entry struct {
+	Id   int    `orm:"auto"`
+	Blob *Blob  `orm:"rel(fk)" json:"-"`
+	Path string `orm:"size(1024)"`
+	Size int64  `orm:"default(0)"`
+	Type string `orm:"size(16)"`
+}
+
+func IsGzip(data []byte) bool {
+	return len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b
+}
+
+func IsTar(data []byte) bool {
+	return len(data) > 262 && string(data[257:262]) == "ustar"
+}
+
+func EntryType(header *tar.Header) string {
+	switch header.Typeflag {
+	case tar.TypeDir:
+		return "dir"
+	case tar.TypeSymlink, tar.TypeLink:
+		return "link"
+	case tar.TypeReg, tar.TypeRegA:
+		return "file"
+	}
+	return "other"
+}
+
+// EntryPath normalizes a member name the way it is looked up: slash
+// separated, without leading "/" or "./".
+func EntryPath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// openArchive returns a tar reader over a tar or tar.gz report, or nil when
+// the report is not an archive.
+func openArchive(content io.Reader) (*tar.Reader, error) {
+	header := make([]byte, 512)
+	n, err := io.ReadFull(content, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, nil
+	}
+
+	header = header[:n]
+	reader := io.MultiReader(bytes.NewReader(header), content)
+
+	if IsGzip(header) {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		peek := make([]byte, 512)
+		n, err := io.ReadFull(gz, peek)
+		if (err != nil && err != io.ErrUnexpectedEOF) || !IsTar(peek[:n]) {
+			return nil, nil
+		}
+
+		return tar.NewReader(io.MultiReader(bytes.NewReader(peek[:n]), gz)), nil
+	}
+
+	if IsTar(header) {
+		return tar.NewReader(reader), nil
+	}
+
+	return nil, nil
+}
+
+// IndexArchive records the members of blob when it is a tar or tar.gz
+// archive. Other files are left alone.
+func IndexArchive(o orm.Ormer, blob *Blob, data []byte) error {
+	if o.QueryTable("entry").Filter("Blob", blob.Id).Exist() {
+		return nil
+	}
+
+	archive, err := openArchive(bytes.NewReader(data))
+	if err != nil || archive == nil {
+		return err
+	}
+
+	var entries []*Entry
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Printf("report %s is not a valid archive, indexing the first entries only: %s", blob.Digest, err)
+			break
+		}
+
+		if len(entries) == MaxArchiveEntries {
+			log.Printf("report %s has more than %d entries, indexing the first ones only", blob.Digest, MaxArchiveEntries)
+			break
+		}
+
+		name := EntryPath(header.Name)
+		if name == "" || len(name) > 1024 {
+			continue
+		}
+
+		entries = append(entries, &Entry{Blob: blob, Path: name, Size: header.Size, Type: EntryType(header)})
+	}
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	if _, err := o.InsertMulti(100, entries); err != nil {
+		return err
+	}
+
+	return IndexContents(o, blob, data)
+}
+
+// IndexBlob indexes blob unless it was already, and flags it so that it is
+// never read for indexing again. Entries and flag are recorded together, the
+// first indexer to commit wins.
+func IndexBlob(o orm.Ormer, blob *Blob, data []byte) error {
+	if blob.Indexed {
+		return nil
+	}
+
+	err := InTransaction(o, func() error {
+		if err := IndexArchive(o, blob, data); err != nil {
+			return err
+		}
+
+		updated, err := o.QueryTable("blob").Filter("Id", blob.Id).Filter("Indexed", false).
+			Update(orm.Params{"Indexed": true})
+		if err == nil && updated == 0 {
+			return errIndexed
+		}
+		return err
+	})
+
+	if err == nil || err == errIndexed {
+		blob.Indexed = true
+		return nil
+	}
+
+	return err
+}
+
+// IndexStoredBlob reads blob from the storage and indexes it.
+func IndexStoredBlob(o orm.Ormer, storage Storage, blob *Blob) error {
+	content, err := storage.Get(BlobKey(blob.Digest))
+	if err != nil {
+		return err
+	}
+
+	defer content.Close()
+
+	data, err := ioutil.ReadAll(content)
+	if err != nil {
+		return err
+	}
+
+	return IndexBlob(o, blob, data)
+}
+
+// WakeIndexer tells the indexer that a blob is waiting.
+func WakeIndexer() {
+	select {
+	case indexWake <- true:
+	default:
+	}
+}
+
+// IndexPending indexes every blob not indexed yet, oldest first. Failures are
+// logged and retried on the next pass.
+func IndexPending(o orm.Ormer, storage Storage) error {
+	lastId := 0
+	for {
+		var blobs []*Blob
+		_, err := o.QueryTable("blob").Filter("Indexed", false).Filter("Id__gt", lastId).
+			OrderBy("Id").Limit(indexBatch).All(&blobs)
+		if err != nil {
+			return err
+		}
+
+		for _, blob := range blobs {
+			if err := IndexStoredBlob(o, storage, blob); err != nil {
+				log.Printf("cannot index blob %s: %s", blob.Digest, err)
+			}
+			lastId = blob.Id
+		}
+
+		if len(blobs) < indexBatch {
+			return nil
+		}
+	}
+}
+
+// StartIndexer indexes uploaded reports in the background, so that uploads
+// do not wait for it. Blobs are flagged once indexed, the pending ones
+// survive restarts.
+func StartIndexer(storage Storage) {
+	go func() {
+		for {
+			if err := IndexPending(orm.NewOrm(), storage); err != nil {
+				log.Printf("indexer failed: %s", err)
+			}
+
+			select {
+			case <-indexWake:
+			case <-time.After(IndexPoll):
+			}
+		}
+	}()
+}
+
+// OpenEntry streams the contents of the member at name in blob.
+func OpenEntry(storage Storage, blob *Blob, name string) (io.Reader, io.Closer, int64, error) {
+	content, err := storage.Get(BlobKey(blob.Digest))
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	archive, err := openArchive(content)
+	if err != nil || archive == nil {
+		content.Close()
+		return nil, nil, 0, ErrEntryNotFound
+	}
+
+	name = EntryPath(name)
+	for {
+		header, err := archive.Next()
+		if err != nil {
+			content.Close()
+			return nil, nil, 0, ErrEntryNotFound
+		}
+
+		if EntryPath(header.Name) == name && EntryType(header) == "file" {
+			return archive, content, header.Size, nil
+		}
+	}
+}
+
+func (handler *CaseHandler) loadFile(o orm.Ormer, request *restful.Request) (*File, error) {
+	file_id, err := strconv.Atoi(request.PathParameter("file-id"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid provided file id")
+	}
+
+	file := File{Id: file_id}
+	if err := o.Read(&file); err != nil || file.Case == nil || file.Case.Id != CurrentCase(request).Id {
+		return nil, fmt.Errorf("not found specified file")
+	}
+
+	if file.Blob == nil || o.Read(file.Blob) != nil {
+		return nil, fmt.Errorf("not found specified file")
+	}
+
+	return &file, nil
+}
+
+// indexFile indexes a file the indexer did not get to yet, so that its
+// entries can be listed right away. Its content is only read the first time.
+func (handler *CaseHandler) indexFile(o orm.Ormer, file *File) error {
+	if file.Blob.Indexed {
+		return nil
+	}
+
+	return IndexStoredBlob(o, handler.Storage, file.Blob)
+}
+
+func (handler *CaseHandler) Entries(request *restful.Request, response *restful.Response) {
+	o := orm.NewOrm()
+
+	file, err := handler.loadFile(o, request)
+	if err != nil {
+		response.WriteErrorString(http.StatusNotFound, err.Error())
+		return
+	}
+
+	if err := handler.indexFile(o, file); err != nil {
+		log.Printf("cannot index file %d: %s", file.Id, err)
+	}
+
+	var entries []*Entry
+	if _, err := o.QueryTable("entry").Filter("Blob", file.Blob.Id).OrderBy("Id").Limit(-1).All(&entries); err != nil {
+		response.WriteErrorString(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.WriteEntity(entries)
+}
+
+func (handler *CaseHandler) GetEntry(request *restful.Request, response *restful.Response) {
+	o := orm.NewOrm()
+
+	file, err := handler.loadFile(o, request)
+	if err != nil {
+		response.WriteErrorString(http.StatusNotFound, err.Error())
+		return
+	}
+
+	name := request.QueryParameter("path")
+	reader, closer, size, err := OpenEntry(handler.Storage, file.Blob, name)
+	if err == ErrEntryNotFound {
+		response.WriteErrorString(http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		response.WriteErrorString(http.StatusInternalServerError, "cannot read file")
+		return
+	}
+
+	defer closer.Close()
+
+	if err := Audit(o, request, AuditEntryRead, CurrentCase(request), file.Id, name); err != nil {
+		response.WriteErrorString(http.StatusInternalServerError, "cannot record audit event")
+		return
+	}
+
+	response.AddHeader("Content-Type", "application/octet-stream")
+	response.AddHeader("Content-Length", strconv.FormatInt(size, 10))
+	response.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(response, reader); err != nil {
+		log.Printf("cannot stream %s of file %d: %s", name, file.Id, err)
+	}
+}
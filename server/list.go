@@ -0,0 +1,333 @@
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/astaxie/beego/orm"
+	"github.com/emicklei/go-restful"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultListLimit = 50
+	MaxListLimit     = 200
+)
+
+// CaseSummary is the listing view of a case, without its configuration and
+// signatures.
+type CaseSummary struct {
+	Id          string
+	Description string
+	Created     time.Time
+	Updated     time.Time
+	IsSigned    bool
+	IsPrivate   bool
+	Status      string
+	Owner       string
+	Team        string
+}
+
+type CaseListResponse struct {
+	Cases []*CaseSummary
+	Next  string
+}
+
+// CaseQuery is a case listing request. Pages are addressed by an opaque
+// cursor holding the sort value and identifier of the last case returned,
+// internal ids are never exposed.
+type CaseQuery struct {
+	Owner         string
+	Status        string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Text          string
+	Signed        string
+	Private       string
+	Sort          string
+	Descending    bool
+	Cursor        string
+	CursorUid     string
+	CursorValue   time.Time
+	Limit         int
+}
+
+var sortFields = map[string]string{
+	"created": "Created",
+	"updated": "Updated",
+}
+
+func NewCaseSummary(c *Case) *CaseSummary {
+	summary := &CaseSummary{
+		Id:          c.Uid,
+		Description: c.Description,
+		Created:     c.Created,
+		Updated:     c.Updated,
+		IsSigned:    c.IsSigned,
+		IsPrivate:   c.IsPrivate,
+		Status:      c.Status,
+		Team:        c.Team,
+	}
+
+	if c.Owner != nil {
+		summary.Owner = c.Owner.Name
+	}
+
+	return summary
+}
+
+func ParseCaseQuery(request *restful.Request) (*CaseQuery, error) {
+	var err error
+
+	query := &CaseQuery{
+		Owner:   request.QueryParameter("owner"),
+		Status:  request.QueryParameter("status"),
+		Text:    request.QueryParameter("q"),
+		Signed:  request.QueryParameter("signed"),
+		Private: request.QueryParameter("private"),
+		Cursor:  request.QueryParameter("cursor"),
+		Limit:   DefaultListLimit,
+	}
+
+	if query.Status != "" && !ValidStatus(query.Status) {
+		return nil, fmt.Errorf("unknown case status: %s", query.Status)
+	}
+
+	if after := request.QueryParameter("created_after"); after != "" {
+		if query.CreatedAfter, err = time.Parse(time.RFC3339, after); err != nil {
+			return nil, fmt.Errorf("invalid created_after, expected RFC 3339: %s", after)
+		}
+	}
+
+	if before := request.QueryParameter("created_before"); before != "" {
+		if query.CreatedBefore, err = time.Parse(time.RFC3339, before); err != nil {
+			return nil, fmt.Errorf("invalid created_before, expected RFC 3339: %s", before)
+		}
+	}
+
+	for _, flag := range []string{query.Signed, query.Private} {
+		if flag != "" && flag != "true" && flag != "false" {
+			return nil, fmt.Errorf("invalid boolean filter: %s", flag)
+		}
+	}
+
+	sort := request.QueryParameter("sort")
+	if sort == "" {
+		sort = "-created"
+	}
+
+	query.Descending = strings.HasPrefix(sort, "-")
+	query.Sort = sortFields[strings.TrimPrefix(sort, "-")]
+	if query.Sort == "" {
+		return nil, fmt.Errorf("invalid sort field: %s", sort)
+	}
+
+	if query.Cursor != "" {
+		if err := query.decodeCursor(); err != nil {
+			return nil, err
+		}
+	}
+
+	if limit := request.QueryParameter("limit"); limit != "" {
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil || query.Limit < 1 || query.Limit > MaxListLimit {
+			return nil, fmt.Errorf("limit must be between 1 and %d", MaxListLimit)
+		}
+	}
+
+	return query, nil
+}
+
+func sortValue(c *Case, field string) time.Time {
+	if field == "Updated" {
+		return c.Updated
+	}
+	return c.Created
+}
+
+func EncodeCursor(c *Case, field string) string {
+	value := sortValue(c, field).UTC().Format(time.RFC3339Nano)
+	return base64.URLEncoding.EncodeToString([]byte(value + "|" + c.Uid))
+}
+
+func (query *CaseQuery) decodeCursor() error {
+	invalid := fmt.Errorf("invalid cursor")
+
+	decoded, err := base64.URLEncoding.DecodeString(query.Cursor)
+	if err != nil {
+		return invalid
+	}
+
+	parts := strings.SplitN(string(decoded), "|", 2)
+	if len(parts) != 2 {
+		return invalid
+	}
+
+	if query.CursorValue, err = time.Parse(time.RFC3339Nano, parts[0]); err != nil || parts[1] == "" {
+		return invalid
+	}
+
+	query.CursorUid = parts[1]
+	return nil
+}
+
+// cursorCondition restricts a listing to the cases from the second of the
+// cursor on in the requested order. Time filters are sent in whole seconds
+// while the database may keep fractions, the cases of that second up to the
+// cursor are skipped by after.
+func cursorCondition(query *CaseQuery) *orm.Condition {
+	second := query.CursorValue.Truncate(time.Second)
+	if query.Descending {
+		return orm.NewCondition().And(query.Sort+"__lt", second.Add(time.Second))
+	}
+	return orm.NewCondition().And(query.Sort+"__gte", second)
+}
+
+// after tells whether c comes after the cursor in the requested order,
+// breaking ties on the case identifier.
+func (query *CaseQuery) after(c *Case) bool {
+	if query.Cursor == "" {
+		return true
+	}
+
+	value := sortValue(c, query.Sort)
+	if !value.Equal(query.CursorValue) {
+		return value.After(query.CursorValue) != query.Descending
+	}
+
+	return c.Uid != query.CursorUid && (c.Uid > query.CursorUid) != query.Descending
+}
+
+// visibleCondition restricts a listing to the cases user may read, following
+// the same rules as CanAccess. It returns nil when nothing is visible.
+func visibleCondition(o orm.Ormer, user *User) (*orm.Condition, error) {
+	cond := orm.NewCondition()
+
+	switch user.Role {
+	case RoleAdmin:
+		return cond, nil
+	case RoleEngineer:
+		visible := orm.NewCondition().Or("Owner", user.Id)
+		if user.Team != "" {
+			visible = visible.Or("Team", user.Team)
+		}
+		return cond.AndCond(visible), nil
+	case RoleCustomer:
+		var ids orm.ParamsList
+		if _, err := o.QueryTable("invitation").Filter("User", user.Id).ValuesFlat(&ids, "Case"); err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			return nil, nil
+		}
+		return cond.And("Id__in", ids...), nil
+	}
+
+	return nil, nil
+}
+
+func ListCases(o orm.Ormer, user *User, query *CaseQuery) (*CaseListResponse, error) {
+	list := &CaseListResponse{Cases: []*CaseSummary{}}
+
+	cond, err := visibleCondition(o, user)
+	if err != nil || cond == nil {
+		return list, err
+	}
+
+	if query.Owner != "" {
+		cond = cond.And("Owner__Name", query.Owner)
+	}
+
+	if query.Status != "" {
+		cond = cond.And("Status", query.Status)
+	}
+
+	if !query.CreatedAfter.IsZero() {
+		cond = cond.And("Created__gte", query.CreatedAfter)
+	}
+
+	if !query.CreatedBefore.IsZero() {
+		cond = cond.And("Created__lt", query.CreatedBefore)
+	}
+
+	if query.Text != "" {
+		cond = cond.And("Description__icontains", query.Text)
+	}
+
+	if query.Signed != "" {
+		cond = cond.And("IsSigned", query.Signed == "true")
+	}
+
+	if query.Private != "" {
+		cond = cond.And("IsPrivate", query.Private == "true")
+	}
+
+	if query.Cursor != "" {
+		cond = cond.AndCond(cursorCondition(query))
+	}
+
+	order := []string{query.Sort, "Uid"}
+	if query.Descending {
+		order = []string{"-" + query.Sort, "-Uid"}
+	}
+
+	qs := o.QueryTable("case").SetCond(cond).OrderBy(order...)
+
+	var cases []*Case
+	for offset := 0; len(cases) <= query.Limit; offset += query.Limit + 1 {
+		var page []*Case
+		read, err := qs.Offset(offset).Limit(query.Limit+1).All(&page, "Id", "Uid", "Description", "Created",
+			"Updated", "IsSigned", "IsPrivate", "Status", "Owner", "Team")
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range page {
+			if query.after(c) {
+				cases = append(cases, c)
+			}
+		}
+
+		if int(read) <= query.Limit {
+			break
+		}
+	}
+
+	if len(cases) > query.Limit {
+		cases = cases[:query.Limit]
+		list.Next = EncodeCursor(cases[len(cases)-1], query.Sort)
+	}
+
+	owners := make(map[int]*User)
+	for _, c := range cases {
+		if c.Owner != nil {
+			if owners[c.Owner.Id] == nil {
+				owners[c.Owner.Id] = c.Owner
+				o.Read(c.Owner)
+			}
+			c.Owner = owners[c.Owner.Id]
+		}
+
+		list.Cases = append(list.Cases, NewCaseSummary(c))
+	}
+
+	return list, nil
+}
+
+func (handler *CaseHandler) List(request *restful.Request, response *restful.Response) {
+	query, err := ParseCaseQuery(request)
+	if err != nil {
+		response.WriteErrorString(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	list, err := ListCases(orm.NewOrm(), CurrentUser(request), query)
+	if err != nil {
+		response.WriteErrorString(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.WriteEntity(list)
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"code.google.com/p/go-uuid/uuid"
+	"encoding/base64"
+	"github.com/astaxie/beego/orm"
+	"github.com/emicklei/go-restful"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCaseCursor(t *testing.T) {
+	c := &Case{
+		Id:      4242,
+		Uid:     "6ba7b810-9dad-11d1-80b4-00c04fd430c8",
+		Created: time.Date(2026, 3, 1, 12, 0, 0, 123, time.UTC),
+		Updated: time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC),
+	}
+
+	for _, field := range []string{"Created", "Updated"} {
+		cursor := EncodeCursor(c, field)
+
+		decoded, _ := base64.URLEncoding.DecodeString(cursor)
+		if strings.Contains(string(decoded), "4242") {
+			t.Errorf("%s: cursor exposes the internal id: %s", field, decoded)
+		}
+
+		query := &CaseQuery{Sort: field, Cursor: cursor}
+		if err := query.decodeCursor(); err != nil {
+			t.Fatalf("%s: %s", field, err)
+		}
+
+		if query.CursorUid != c.Uid || !query.CursorValue.Equal(sortValue(c, field)) {
+			t.Errorf("%s: decoded %s %s", field, query.CursorValue, query.CursorUid)
+		}
+	}
+
+	for _, cursor := range []string{"!", base64.URLEncoding.EncodeToString([]byte("4242|")),
+		base64.URLEncoding.EncodeToString([]byte("2026-03-01T12:00:00Z|"))} {
+		query := &CaseQuery{Sort: "Created", Cursor: cursor}
+		if err := query.decodeCursor(); err == nil {
+			t.Errorf("%q: expected an invalid cursor", cursor)
+		}
+	}
+}
+
+// listAll follows the cursors of a listing with params and returns the
+// identifiers of every case in the order received.
+func listAll(t *testing.T, o orm.Ormer, user *User, params url.Values) []string {
+	var uids []string
+
+	for pages := 0; pages < 100; pages++ {
+		httpRequest, _ := http.NewRequest("GET", "/1/case?"+params.Encode(), nil)
+
+		query, err := ParseCaseQuery(restful.NewRequest(httpRequest))
+		if err != nil {
+			t.Fatalf("%s: %s", params.Encode(), err)
+		}
+
+		list, err := ListCases(o, user, query)
+		if err != nil {
+			t.Fatalf("%s: %s", params.Encode(), err)
+		}
+
+		for _, summary := range list.Cases {
+			uids = append(uids, summary.Id)
+		}
+
+		if list.Next == "" {
+			return uids
+		}
+		params.Set("cursor", list.Next)
+	}
+
+	t.Fatalf("%s: listing does not end", params.Encode())
+	return nil
+}
+
+func TestListCases(t *testing.T) {
+	o := openTestDatabase(t)
+
+	rows := &testRows{t: t, o: o}
+	defer rows.remove()
+
+	engineer := rows.user("list-engineer", RoleEngineer, "list-team")
+	teammate := rows.user("list-teammate", RoleEngineer, "list-team")
+	other := rows.user("list-other", RoleEngineer, "list-other-team")
+	customer := rows.user("list-customer", RoleCustomer, "")
+
+	// Fractions of seconds and equal times exercise the cursor tie-breaks.
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	newCase := func(owner *User, team string, description string, status string, created time.Duration) *Case {
+		c := &Case{Uid: uuid.New(), Owner: owner, Team: team, Description: description, Status: status,
+			Created: base.Add(created), Updated: base.Add(created)}
+		rows.insert(c)
+		return c
+	}
+
+	own := newCase(engineer, "", "kernel panic", StatusOpen, 250*time.Millisecond)
+	team := newCase(teammate, "list-team", "disk full", StatusInvestigating, 1500*time.Millisecond)
+	teamClosed := newCase(teammate, "list-team", "kernel oops", StatusClosed, 1500*time.Millisecond)
+	otherTeam := newCase(other, "list-other-team", "kernel panic elsewhere", StatusOpen, time.Second)
+	invited := newCase(other, "list-other-team", "customer report", StatusOpen, 3*time.Second)
+
+	rows.invite(invited, customer)
+
+	tests := []struct {
+		user   *User
+		params url.Values
+		want   []*Case
+	}{
+		{engineer, url.Values{}, []*Case{own, team, teamClosed}},
+		{engineer, url.Values{"status": {StatusInvestigating}}, []*Case{team}},
+		{engineer, url.Values{"q": {"KERNEL"}}, []*Case{own, teamClosed}},
+		{engineer, url.Values{"owner": {"list-teammate"}}, []*Case{team, teamClosed}},
+		{other, url.Values{}, []*Case{otherTeam, invited}},
+		{customer, url.Values{}, []*Case{invited}},
+	}
+
+	for _, test := range tests {
+		var want []string
+		for _, c := range test.want {
+			want = append(want, c.Uid)
+		}
+
+		for _, order := range []string{"created", "-created", "updated", "-updated"} {
+			// Smaller pages must return the cases of one page in the same order.
+			var whole []string
+
+			for _, limit := range []string{"50", "2", "1"} {
+				params := url.Values{"sort": {order}, "limit": {limit}}
+				for key, values := range test.params {
+					params[key] = values
+				}
+
+				got := listAll(t, o, test.user, params)
+
+				if !sameCases(got, want) {
+					t.Errorf("%s with %s: got %v, want %v", test.user.Name, params.Encode(), got, want)
+				} else if whole == nil {
+					whole = got
+				} else if strings.Join(got, ",") != strings.Join(whole, ",") {
+					t.Errorf("%s with %s: paged order %v, want %v", test.user.Name, params.Encode(), got, whole)
+				}
+			}
+		}
+	}
+}
+
+// sameCases tells whether got lists every case of want exactly once.
+func sameCases(got []string, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+
+	sorted := append([]string{}, got...)
+	expected := append([]string{}, want...)
+	sort.Strings(sorted)
+	sort.Strings(expected)
+
+	for i := range sorted {
+		if sorted[i] != expected[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -7,15 +7,15 @@ import (
 	"github.com/astaxie/beego/orm"
 	"github.com/emicklei/go-restful"
 	//"github.com/emicklei/go-restful/swagger"
-	_ "github.com/mattn/go-sqlite3"
 	"io/ioutil"
 	"log"
 	"mayday/core"
+	"net"
 	"net/http"
-	"os"
-	"path"
 	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 const (
@@ -23,37 +23,144 @@ const (
 )
 
 type File struct {
-	Id      int `orm:"auto"`
-	Path    string
-	Created time.Time `orm:"auto_now_add;type(datetime)"`
-	Case    *Case     `orm:"rel(fk)"`
+	Id          int `orm:"auto"`
+	Path        string
+	Size        int64     `orm:"default(0)"`
+	Digest      string    `orm:"default();size(64)"`
+	ContentType string    `orm:"default();size(128)"`
+	Uploader    string    `orm:"default();size(128)"`
+	Hostname    string    `orm:"default();size(255)"`
+	Version     string    `orm:"default();size(32)"`
+	Created     time.Time `orm:"auto_now_add;type(datetime)"`
+	Case        *Case     `orm:"rel(fk)" json:"-"`
+	Blob        *Blob     `orm:"null;rel(fk)" json:"-"`
 }
 
 type UploadFile struct {
 	Filename string
 	Content  string
+	Hostname string `json:",omitempty"`
+	Version  string `json:",omitempty"`
+}
+
+// CaseRequest holds what a client sets when creating a case. Everything else,
+// legal hold and envelope included, is managed by the server.
+type CaseRequest struct {
+	Description string
+	IsPrivate   bool
+	Config      string
+	Signed      string
+}
+
+type SignatureRequest struct {
+	Envelope  string
+	Signature string
 }
 
 type Case struct {
-	Id          int       `orm:"auto"`
-	Description string    `orm:"default(""), type(text)"`
+	Id          int       `orm:"auto" json:"-"`
+	Uid         string    `orm:"unique;size(36)" json:"Id"`
+	Description string    `orm:"default();type(text)"`
 	Created     time.Time `orm:"auto_now_add;type(datetime)"`
 	Updated     time.Time `orm:"auto_now_add;type(datetime)"`
 	IsSigned    bool      `orm:"default(false)"`
 	IsPrivate   bool      `orm:"default(false)"`
 	Token       string
-	Config      string  `orm:"default(""), type(text)"`
-	Signed      string  `orm:"default(""), type(text)"`
+	Config      string  `orm:"default();type(text)"`
+	Signed      string  `orm:"default();type(text)"`
+	Envelope    string  `orm:"default();type(text)"`
+	Owner       *User   `orm:"null;rel(fk);on_delete(set_null)"`
+	Team        string  `orm:"default();size(128)"`
+	Status      string  `orm:"default(open);size(32)"`
+	LegalHold   bool    `orm:"default(false)"`
 	Files       []*File `orm:"reverse(many)"`
+	Usage       *Usage  `orm:"-" json:",omitempty"`
+}
+
+// RequestToken returns the bearer token sent in the Authorization header.
+// The token query parameter is still accepted during its deprecation window.
+func RequestToken(request *restful.Request) string {
+	authorization := request.HeaderParameter("Authorization")
+	if strings.HasPrefix(authorization, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+	}
+
+	if token := request.QueryParameter("token"); token != "" {
+		log.Printf("deprecated token query parameter used by %s on %s",
+			request.Request.RemoteAddr, request.Request.URL.Path)
+		return token
+	}
+
+	return ""
+}
+
+// Uploader names who sent a request: the user, or the kind of anonymous
+// access it used.
+func Uploader(request *restful.Request) string {
+	if user := CurrentUser(request); user != nil {
+		return Truncate(user.Name, 128)
+	}
+
+	if c := CurrentCase(request); c != nil && c.IsPrivate {
+		return "case token"
+	}
+
+	return "anonymous"
+}
+
+// maxQueryIds bounds the values of an IN filter, SQLite allows 999 variables
+// per statement.
+const maxQueryIds = 500
+
+// ChunkIds splits ids for IN filters of at most maxQueryIds values.
+func ChunkIds(ids []interface{}) [][]interface{} {
+	var chunks [][]interface{}
+	for len(ids) > maxQueryIds {
+		chunks = append(chunks, ids[:maxQueryIds])
+		ids = ids[maxQueryIds:]
+	}
+
+	if len(ids) > 0 {
+		chunks = append(chunks, ids)
+	}
+	return chunks
+}
+
+// InTransaction runs fn in a transaction on o, rolled back when fn fails.
+func InTransaction(o orm.Ormer, fn func() error) error {
+	if err := o.Begin(); err != nil {
+		return err
+	}
+
+	if err := fn(); err != nil {
+		o.Rollback()
+		return err
+	}
+
+	return o.Commit()
+}
+
+// Truncate shortens value to at most size bytes without splitting a
+// character, databases reject invalid UTF-8.
+func Truncate(value string, size int) string {
+	if len(value) <= size {
+		return value
+	}
+
+	for size > 0 && !utf8.RuneStart(value[size]) {
+		size--
+	}
+	return value[:size]
 }
 
 type CaseHandler struct {
-	StoragePath string
+	Storage Storage
+	Quota   QuotaConfig
 }
 
 func (handler *CaseHandler) Create(request *restful.Request, response *restful.Response) {
-	c := new(Case)
-	err := request.ReadEntity(c)
+	r := new(CaseRequest)
+	err := request.ReadEntity(r)
 
 	if err != nil {
 		response.AddHeader("Content-Type", "application/json")
@@ -61,8 +168,18 @@ func (handler *CaseHandler) Create(request *restful.Request, response *restful.R
 		return
 	}
 
-	if c.IsPrivate {
-		c.Token = uuid.New()
+	c := &Case{
+		Uid:         uuid.New(),
+		Description: r.Description,
+		IsPrivate:   r.IsPrivate,
+		Config:      r.Config,
+		Signed:      r.Signed,
+		Status:      StatusOpen,
+	}
+
+	if user := CurrentUser(request); user != nil {
+		c.Owner = user
+		c.Team = user.Team
 	}
 
 	if c.Signed != "" {
@@ -70,67 +187,68 @@ func (handler *CaseHandler) Create(request *restful.Request, response *restful.R
 	}
 
 	o := orm.NewOrm()
-	o.Insert(c)
 
-	response.WriteHeader(http.StatusCreated)
-	response.WriteEntity(c)
-}
-
-func (handler *CaseHandler) Get(request *restful.Request, response *restful.Response) {
-	id, err := strconv.Atoi(request.PathParameter("case-id"))
+	err = InTransaction(o, func() error {
+		if _, err := o.Insert(c); err != nil {
+			return err
+		}
 
-	if err != nil {
-		response.WriteErrorString(http.StatusInternalServerError, "invalid provided id")
-		return
-	}
+		if err := AppendAudit(o, NewAuditEvent(request, AuditCaseCreate, c, 0, "")); err != nil {
+			return err
+		}
 
-	c := Case{Id: id}
-	o := orm.NewOrm()
+		if !c.IsPrivate {
+			return nil
+		}
 
-	err = o.Read(&c)
+		created, err := NewToken(o, c, []string{ScopeRead, ScopeUpload, ScopeDownload}, NoExpiry, 0, "initial case token")
+		if err != nil {
+			return err
+		}
 
+		c.Token = created.Secret
+		return AppendAudit(o, NewAuditEvent(request, AuditTokenCreate, c, 0, fmt.Sprintf("token %d", created.Token.Id)))
+	})
 	if err != nil {
-		response.WriteErrorString(http.StatusNotFound, err.Error())
+		response.WriteErrorString(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	if c.IsPrivate {
-		token := request.QueryParameter("token")
-		if c.Token != token || token == "" {
-			response.WriteErrorString(http.StatusForbidden, "Invalid Token")
-			return
-		}
-	}
+	Notify(o, EventCaseCreated, c, nil)
 
-	o.LoadRelated(&c, "Files")
+	response.WriteHeader(http.StatusCreated)
 	response.WriteEntity(c)
 }
 
-func (handler *CaseHandler) GetFile(request *restful.Request, response *restful.Response) {
-	id, err := strconv.Atoi(request.PathParameter("case-id"))
+func (handler *CaseHandler) Get(request *restful.Request, response *restful.Response) {
+	c := CurrentCase(request)
+	o := orm.NewOrm()
 
+	o.LoadRelated(c, "Files")
+
+	usage, err := CaseUsage(o, &handler.Quota, c)
 	if err != nil {
-		response.WriteErrorString(http.StatusInternalServerError, "invalid provided id")
+		response.WriteErrorString(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	c := Case{Id: id}
-	o := orm.NewOrm()
+	if !SeesOwnerUsage(CurrentUser(request), c) {
+		usage.OwnerBytes, usage.OwnerQuota = 0, 0
+	}
 
-	err = o.Read(&c)
+	c.Usage = usage
 
-	if err != nil {
-		response.WriteErrorString(http.StatusNotFound, err.Error())
+	if err := Audit(o, request, AuditCaseRead, c, 0, ""); err != nil {
+		response.WriteErrorString(http.StatusInternalServerError, "cannot record audit event")
 		return
 	}
 
-	if c.IsPrivate {
-		token := request.QueryParameter("token")
-		if c.Token != token || token == "" {
-			response.WriteErrorString(http.StatusForbidden, "Invalid Token")
-			return
-		}
-	}
+	response.WriteEntity(c)
+}
+
+func (handler *CaseHandler) GetFile(request *restful.Request, response *restful.Response) {
+	c := CurrentCase(request)
+	o := orm.NewOrm()
 
 	file_id, err := strconv.Atoi(request.PathParameter("file-id"))
 	if err != nil {
@@ -138,18 +256,33 @@ func (handler *CaseHandler) GetFile(request *restful.Request, response *restful.
 		return
 	}
 
-	o.LoadRelated(&c, "Files")
+	o.LoadRelated(c, "Files")
 
 	for _, file := range c.Files {
 		if file.Id == file_id {
-			fullpath := path.Join(handler.StoragePath, strconv.Itoa(id), file.Path)
+			if file.Blob == nil || o.Read(file.Blob) != nil {
+				response.WriteErrorString(http.StatusInternalServerError, "cannot read file")
+				return
+			}
+
+			content, err := handler.Storage.Get(BlobKey(file.Blob.Digest))
+			if err != nil {
+				response.WriteErrorString(http.StatusInternalServerError, "cannot read file")
+				return
+			}
 
-			readed, err := ioutil.ReadFile(fullpath)
+			readed, err := ioutil.ReadAll(content)
+			content.Close()
 			if err != nil {
 				response.WriteErrorString(http.StatusInternalServerError, "cannot read file")
 				return
 			}
 
+			if err := Audit(o, request, AuditFileDownload, c, file.Id, file.Path); err != nil {
+				response.WriteErrorString(http.StatusInternalServerError, "cannot record audit event")
+				return
+			}
+
 			response.WriteEntity(UploadFile{
 				Filename: file.Path,
 				Content:  base64.StdEncoding.EncodeToString(readed),
@@ -163,43 +296,34 @@ func (handler *CaseHandler) GetFile(request *restful.Request, response *restful.
 }
 
 func (handler *CaseHandler) UploadFiles(request *restful.Request, response *restful.Response) {
-	id, err := strconv.Atoi(request.PathParameter("case-id"))
+	c := CurrentCase(request)
 
-	if err != nil {
-		response.WriteErrorString(http.StatusInternalServerError, "invalid provided id")
+	if !AcceptsUploads(c) {
+		response.WriteErrorString(http.StatusConflict, "case is "+c.Status+" and does not accept uploads")
 		return
 	}
 
-	c := Case{Id: id}
-	o := orm.NewOrm()
-
-	err = o.Read(&c)
-
-	if err != nil {
-		response.WriteErrorString(http.StatusNotFound, err.Error())
+	if !LimitUpload(&handler.Quota, request, response) {
 		return
 	}
 
-	if c.IsPrivate {
-		token := request.QueryParameter("token")
-		if c.Token != token || token == "" {
-			response.WriteErrorString(http.StatusForbidden, "Invalid Token")
-			return
-		}
-	}
-
 	f := new(UploadFile)
-	err = request.ReadEntity(f)
+	err := request.ReadEntity(f)
 
-	if err != nil {
+	if err != nil && IsBodyTooLarge(err) {
+		response.WriteErrorString(http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("upload is larger than the maximum upload size of %d bytes", handler.Quota.MaxUploadSize))
+		return
+	} else if err != nil {
 		response.AddHeader("Content-Type", "application/json")
 		response.WriteErrorString(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	base := path.Join(handler.StoragePath, strconv.Itoa(id))
-	if _, err := os.Stat(base); os.IsNotExist(err) {
-		os.Mkdir(base, 0700)
+	f.Filename, err = core.SanitizeFilename(f.Filename)
+	if err != nil {
+		response.WriteErrorString(http.StatusBadRequest, err.Error())
+		return
 	}
 
 	data, err := base64.StdEncoding.DecodeString(f.Content)
@@ -208,8 +332,15 @@ func (handler *CaseHandler) UploadFiles(request *restful.Request, response *rest
 		return
 	}
 
-	output := path.Join(base, f.Filename)
-	err = ioutil.WriteFile(output, data, 0700)
+	// Checked again when recording the file, this spares storing uploads
+	// that cannot fit.
+	o := orm.NewOrm()
+	if status, err := CheckQuota(o, &handler.Quota, c, int64(len(data))); err != nil {
+		response.WriteErrorString(status, err.Error())
+		return
+	}
+
+	blob, err := StoreBlob(o, handler.Storage, data)
 
 	if err != nil {
 		response.WriteErrorString(http.StatusInternalServerError, "Cannot store file")
@@ -218,36 +349,188 @@ func (handler *CaseHandler) UploadFiles(request *restful.Request, response *rest
 
 	new_file := &File{}
 	new_file.Path = f.Filename
-	new_file.Case = &c
+	new_file.Size = blob.Size
+	new_file.Digest = blob.Digest
+	new_file.ContentType = http.DetectContentType(data)
+	new_file.Uploader = Uploader(request)
+	new_file.Hostname = Truncate(f.Hostname, 255)
+	new_file.Version = Truncate(f.Version, 32)
+	new_file.Case = c
+	new_file.Blob = blob
+
+	quotaStatus := 0
+	err = InTransaction(o, func() error {
+		if err := LockQuota(o, &handler.Quota, c); err != nil {
+			return err
+		}
+
+		status, err := CheckQuota(o, &handler.Quota, c, blob.Size)
+		if err != nil {
+			quotaStatus = status
+			return err
+		}
+
+		if _, err := o.Insert(new_file); err != nil {
+			return err
+		}
+
+		return AppendAudit(o, NewAuditEvent(request, AuditFileUpload, c, new_file.Id, new_file.Path))
+	})
+
+	if err != nil {
+		DropBlob(o, handler.Storage, blob)
+		if quotaStatus != 0 {
+			response.WriteErrorString(quotaStatus, err.Error())
+		} else {
+			response.WriteErrorString(http.StatusInternalServerError, "Cannot store file")
+		}
+		return
+	}
+
+	if c.Status != StatusReportReceived {
+		if err := Transition(o, c, StatusReportReceived, CurrentUser(request), "report uploaded"); err != nil {
+			log.Printf("cannot mark case %s as %s: %s", c.Uid, StatusReportReceived, err)
+		}
+	}
+
+	WakeIndexer()
+
+	Notify(o, EventFileUploaded, c, new_file)
+
+	response.WriteHeader(http.StatusCreated)
+	response.WriteEntity(new_file)
+}
+
+func (handler *CaseHandler) AddSignature(request *restful.Request, response *restful.Response) {
+	c := CurrentCase(request)
+	o := orm.NewOrm()
+
+	s := new(SignatureRequest)
+	err := request.ReadEntity(s)
+
+	if err != nil {
+		response.WriteErrorString(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	signatures := core.SplitSignatures(s.Signature)
+	if len(signatures) == 0 {
+		response.WriteErrorString(http.StatusBadRequest, "invalid detached signature")
+		return
+	}
+
+	if s.Envelope != "" && s.Envelope != c.Envelope {
+		if err := CheckEnvelope(c, s.Envelope, time.Now()); err != nil {
+			response.WriteErrorString(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if c.Signed != "" && !EnvelopeOutdated(c) {
+			response.WriteErrorString(http.StatusConflict,
+				"case is already signed for its configuration, co-sign the existing envelope")
+			return
+		}
+
+		c.Envelope = s.Envelope
+		c.Signed = ""
+	} else if c.Envelope == "" {
+		response.WriteErrorString(http.StatusBadRequest, "missing signed envelope")
+		return
+	}
+
+	for _, signature := range signatures {
+		if !strings.Contains(c.Signed, signature) {
+			c.Signed = core.JoinSignatures(c.Signed, signature)
+		}
+	}
+
+	c.IsSigned = true
+	c.Updated = time.Now()
+
+	if _, err := o.Update(c, "Envelope", "Signed", "IsSigned", "Updated"); err != nil {
+		response.WriteErrorString(http.StatusInternalServerError, "cannot store signature")
+		return
+	}
 
-	o.Insert(new_file)
+	Notify(o, EventConfigUpdated, c, nil)
 
 	response.WriteHeader(http.StatusCreated)
+	response.WriteEntity(c)
 }
 
-func init() {
-	orm.RegisterModel(new(Case), new(File))
-	orm.RegisterDataBase("default", "sqlite3", "/tmp/database.db", 30)
-	orm.RunCommand()
+// CheckEnvelope tells whether a signed envelope binds to c and its stored
+// configuration and is still valid.
+func CheckEnvelope(c *Case, raw string, now time.Time) error {
+	envelope, err := core.ParseEnvelope(raw)
+	if err != nil {
+		return err
+	}
+
+	if envelope.CaseId != c.Uid {
+		return fmt.Errorf("envelope was issued for case %s, not %s", envelope.CaseId, c.Uid)
+	}
+
+	if envelope.ConfigSHA256 != core.ConfigDigest(&core.Config{Raw: c.Config}) {
+		return fmt.Errorf("envelope does not match the case configuration")
+	}
+
+	if !now.Before(envelope.ExpiresAt) {
+		return fmt.Errorf("envelope expired at %s", envelope.ExpiresAt)
+	}
+
+	return nil
+}
 
-	name := "default"
-	force := false
-	verbose := false
+// EnvelopeOutdated tells whether the envelope of c was signed for another
+// configuration, so that it may be replaced.
+func EnvelopeOutdated(c *Case) bool {
+	if c.Envelope == "" {
+		return true
+	}
 
-	err := orm.RunSyncdb(name, force, verbose)
+	envelope, err := core.ParseEnvelope(c.Envelope)
 	if err != nil {
-		fmt.Println(err)
+		return true
 	}
+
+	return envelope.ConfigSHA256 != core.ConfigDigest(&core.Config{Raw: c.Config})
 }
 
-func Start(env core.Environment, bind string, port int, storage string) {
-	handler := &CaseHandler{}
+func Start(config *Config) {
+	storage, err := NewStorage(&config.Storage)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	if _, err := os.Stat(storage); os.IsNotExist(err) || storage == "" {
-		storage, _ = env.GetDefaultStoragePath()
+	if config.Retention.Enabled() {
+		if err := StartReaper(storage, &config.Retention); err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	handler.StoragePath = storage
+	StartWebhooks()
+	StartIndexer(storage)
+
+	container := NewContainer(config, storage)
+
+	// config := swagger.Config{
+	// 	WebServices:    container.RegisteredWebServices(),
+	// 	WebServicesUrl: "http://localhost:8080",
+	// 	ApiPath:        "/apidocs.json",
+	// 	SwaggerPath:    "/apidocs/",
+	// }
+
+	// swagger.RegisterSwaggerService(config, container)
+	addr := net.JoinHostPort(config.Bind, strconv.Itoa(config.Port))
+	log.Printf("start listening on %s", addr)
+	server := &http.Server{Addr: addr, Handler: container}
+	log.Fatal(server.ListenAndServe())
+}
+
+// NewContainer registers the web services of the API. Each route carries an
+// Authorize filter with the action it needs.
+func NewContainer(config *Config, storage Storage) *restful.Container {
+	handler := &CaseHandler{Storage: storage, Quota: config.Quota}
 
 	ws := new(restful.WebService)
 	ws.Path("/1/case").
@@ -255,44 +538,116 @@ func Start(env core.Environment, bind string, port int, storage string) {
 		Consumes(restful.MIME_JSON).
 		Produces(restful.MIME_JSON)
 
-	ws.Route(ws.GET("/{case-id}").To(handler.Get).
+	ws.Route(ws.GET("/{case-id}").Filter(Authorize(ActionRead)).To(handler.Get).
 		Doc("get a specific report").
 		Operation("findCase").
-		Param(ws.PathParameter("case-id", "case identifier").DataType("int")).
-		Param(ws.QueryParameter("token", "private token identifier")).
+		Param(ws.PathParameter("case-id", "case identifier").DataType("string")).
+		Param(ws.HeaderParameter("Authorization", "Bearer private token identifier")).
+		Param(ws.QueryParameter("token", "private token identifier (deprecated)")).
 		Writes(Case{}))
 
-	ws.Route(ws.GET("/{case-id}/file/{file-id}").To(handler.GetFile).
+	ws.Route(ws.GET("/{case-id}/file/{file-id}").Filter(Authorize(ActionDownload)).To(handler.GetFile).
 		Doc("get a specific file report").
 		Operation("findCase").
-		Param(ws.PathParameter("case-id", "case identifier").DataType("int")).
+		Param(ws.PathParameter("case-id", "case identifier").DataType("string")).
 		Param(ws.PathParameter("file-id", "file identifier").DataType("int")).
-		Param(ws.QueryParameter("token", "private token identifier")).
+		Param(ws.HeaderParameter("Authorization", "Bearer private token identifier")).
+		Param(ws.QueryParameter("token", "private token identifier (deprecated)")).
 		Writes(Case{}))
 
-	ws.Route(ws.POST("/{case-id}/file").To(handler.UploadFiles).
+	ws.Route(ws.GET("/{case-id}/file/{file-id}/entries").Filter(Authorize(ActionDownload)).To(handler.Entries).
+		Doc("list the entries of a report archive").
+		Param(ws.PathParameter("case-id", "case identifier").DataType("string")).
+		Param(ws.PathParameter("file-id", "file identifier").DataType("int")).
+		Param(ws.HeaderParameter("Authorization", "Bearer private token identifier")).
+		Writes([]Entry{}))
+
+	ws.Route(ws.GET("/{case-id}/file/{file-id}/entry").Filter(Authorize(ActionDownload)).To(handler.GetEntry).
+		Doc("stream the raw contents of one entry of a report archive").
+		Produces(restful.MIME_OCTET).
+		Param(ws.PathParameter("case-id", "case identifier").DataType("string")).
+		Param(ws.PathParameter("file-id", "file identifier").DataType("int")).
+		Param(ws.QueryParameter("path", "path of the entry inside the archive")).
+		Param(ws.HeaderParameter("Authorization", "Bearer private token identifier")))
+
+	ws.Route(ws.DELETE("/{case-id}/file/{file-id}").Filter(Authorize(ActionManage)).To(handler.DeleteFile).
+		Doc("delete a file of a case").
+		Param(ws.PathParameter("case-id", "case identifier").DataType("string")).
+		Param(ws.PathParameter("file-id", "file identifier").DataType("int")))
+
+	ws.Route(ws.DELETE("/{case-id}").Filter(Authorize(ActionManage)).To(handler.Delete).
+		Doc("delete a case and all its files").
+		Param(ws.PathParameter("case-id", "case identifier").DataType("string")))
+
+	ws.Route(ws.PUT("/{case-id}/hold").Filter(Authorize(ActionAdmin)).To(handler.SetHold).
+		Doc("place or lift a legal hold, which exempts a case from deletion").
+		Param(ws.PathParameter("case-id", "case identifier").DataType("string")).
+		Reads(HoldRequest{}).
+		Writes(Case{}))
+
+	ws.Route(ws.POST("/{case-id}/file").Filter(Authorize(ActionUpload)).To(handler.UploadFiles).
 		Doc("Upload a file to a specific case").
-		Param(ws.PathParameter("case-id", "case identifier").DataType("int")).
-		Param(ws.QueryParameter("token", "private token identifier")).
+		Param(ws.PathParameter("case-id", "case identifier").DataType("string")).
+		Param(ws.HeaderParameter("Authorization", "Bearer private token identifier")).
+		Param(ws.QueryParameter("token", "private token identifier (deprecated)")).
+		Reads(UploadFile{}).
+		Writes(File{}))
+
+	ws.Route(ws.POST("/{case-id}/signature").Filter(Authorize(ActionManage)).To(handler.AddSignature).
+		Doc("Add a detached signature to the configuration of a case").
+		Param(ws.PathParameter("case-id", "case identifier").DataType("string")).
+		Param(ws.HeaderParameter("Authorization", "Bearer private token identifier")).
+		Param(ws.QueryParameter("token", "private token identifier (deprecated)")).
+		Reads(SignatureRequest{}).
 		Writes(Case{}))
 
-	ws.Route(ws.POST("").To(handler.Create).
+	ws.Route(ws.POST("/{case-id}/invite").Filter(Authorize(ActionManage)).To(handler.Invite).
+		Doc("Invite a customer to a case").
+		Param(ws.PathParameter("case-id", "case identifier").DataType("string")).
+		Reads(InvitationRequest{}).
+		Writes(User{}))
+
+	ws.Route(ws.POST("/{case-id}/status").Filter(Authorize(ActionManage)).To(handler.SetStatus).
+		Doc("Move a case to another status").
+		Param(ws.PathParameter("case-id", "case identifier").DataType("string")).
+		Reads(StatusRequest{}).
+		Writes(Case{}))
+
+	ws.Route(ws.GET("/{case-id}/history").Filter(Authorize(ActionRead)).To(handler.History).
+		Doc("List the status changes of a case").
+		Param(ws.PathParameter("case-id", "case identifier").DataType("string")).
+		Param(ws.HeaderParameter("Authorization", "Bearer private token identifier")).
+		Writes([]CaseTransition{}))
+
+	AddTokenRoutes(ws)
+	AddCommentRoutes(ws)
+
+	ws.Route(ws.GET("").Filter(Authorize(ActionList)).To(handler.List).
+		Doc("list the cases visible to the caller").
+		Operation("listCases").
+		Param(ws.QueryParameter("owner", "name of the case owner")).
+		Param(ws.QueryParameter("status", "case status")).
+		Param(ws.QueryParameter("created_after", "RFC 3339 time, inclusive")).
+		Param(ws.QueryParameter("created_before", "RFC 3339 time, exclusive")).
+		Param(ws.QueryParameter("q", "text searched in the description")).
+		Param(ws.QueryParameter("signed", "true or false")).
+		Param(ws.QueryParameter("private", "true or false")).
+		Param(ws.QueryParameter("sort", "created or updated, prefixed by - for descending order")).
+		Param(ws.QueryParameter("cursor", "next page cursor of a previous listing")).
+		Param(ws.QueryParameter("limit", "maximum number of cases returned").DataType("int")).
+		Writes(CaseListResponse{}))
+
+	ws.Route(ws.POST("").Filter(Authorize(ActionCreate)).To(handler.Create).
 		Doc("create a case").
 		Operation("createCase").
-		Reads(Case{})) // from the request
+		Reads(CaseRequest{})) // from the request
 
 	container := restful.NewContainer()
 	container.Add(ws)
+	container.Add(UserWebService())
+	container.Add(SearchWebService(storage))
+	container.Add(WebhookWebService())
+	container.Add(AuditWebService())
 
-	// config := swagger.Config{
-	// 	WebServices:    container.RegisteredWebServices(),
-	// 	WebServicesUrl: "http://localhost:8080",
-	// 	ApiPath:        "/apidocs.json",
-	// 	SwaggerPath:    "/apidocs/",
-	// }
-
-	// swagger.RegisterSwaggerService(config, container)
-	log.Printf("start listening on localhost:%s", port)
-	server := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: container}
-	log.Fatal(server.ListenAndServe())
+	return container
 }
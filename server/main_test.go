@@ -0,0 +1,72 @@
+package server
+
+import (
+	"github.com/emicklei/go-restful"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestCreateCaseIgnoresServerFields(t *testing.T) {
+	o := openTestDatabase(t)
+
+	rows := &testRows{t: t, o: o}
+	defer rows.remove()
+
+	engineer := rows.user("create-engineer", RoleEngineer, "support")
+
+	body := `{"Description": "crash", "IsPrivate": false, "LegalHold": true, "Envelope": "forged",
+		"IsSigned": true, "Status": "closed", "Team": "other"}`
+
+	httpRequest, _ := http.NewRequest("POST", "/1/case", strings.NewReader(body))
+	httpRequest.Header.Set("Content-Type", restful.MIME_JSON)
+
+	request := restful.NewRequest(httpRequest)
+	request.SetAttribute(UserAttribute, engineer)
+
+	recorder := httptest.NewRecorder()
+	(&CaseHandler{}).Create(request, restful.NewResponse(recorder))
+
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("got status %d: %s", recorder.Code, recorder.Body)
+	}
+
+	c := new(Case)
+	if err := o.QueryTable("case").Filter("Owner", engineer.Id).One(c); err != nil {
+		t.Fatal(err)
+	}
+	rows.rows = append(rows.rows, c)
+
+	if c.Description != "crash" {
+		t.Errorf("description %q not kept", c.Description)
+	}
+
+	if c.LegalHold || c.Envelope != "" || c.IsSigned || c.Status != StatusOpen || c.Team != "support" {
+		t.Errorf("server managed fields taken from the request: %+v", c)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		value string
+		size  int
+		want  string
+	}{
+		{"report", 10, "report"},
+		{"report", 3, "rep"},
+		{"café", 4, "caf"},
+		{"café", 5, "café"},
+		{"報告書", 7, "報告"},
+		{"報告書", 2, ""},
+		{"a😀b", 4, "a"},
+	}
+
+	for _, test := range tests {
+		got := Truncate(test.value, test.size)
+		if got != test.want || !utf8.ValidString(got) {
+			t.Errorf("%q to %d: got %q, want %q", test.value, test.size, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,1011 @@
+package server
+
+import (
+	"bytes"
+	"code.google.com/p/go-uuid/uuid"
+	"fmt"
+	"github.com/astaxie/beego/orm"
+	"io/ioutil"
+	"log"
+	"path"
+	"strings"
+	"time"
+)
+
+// SchemaMigration records an applied migration in the database.
+type SchemaMigration struct {
+	Version int       `orm:"pk"`
+	Name    string    `orm:"size(255)"`
+	Applied time.Time `orm:"type(datetime)"`
+}
+
+func (m *SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Migration is one ordered, versioned schema change. Versions must be
+// strictly increasing and never reused once released.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(m *Migrator) error
+	Down    func(m *Migrator) error
+}
+
+type MigrationStatus struct {
+	Migration *Migration
+	Applied   *SchemaMigration
+}
+
+// Migrator runs migrations against the default database, inside a
+// transaction on backends with transactional DDL.
+type Migrator struct {
+	Driver     string
+	Storage    Storage
+	Migrations []*Migration
+	o          orm.Ormer
+}
+
+var Migrations = []*Migration{
+	{
+		Version: 1,
+		Name:    "initial schema",
+		Up:      migrateInitialSchema,
+		Down: func(m *Migrator) error {
+			return m.DropTables("token", "invitation", "user", "file", "case")
+		},
+	},
+	{
+		Version: 2,
+		Name:    "public case identifiers",
+		Up: func(m *Migrator) error {
+			if err := migrateCaseIdentifiers(m); err != nil {
+				return err
+			}
+			return m.CreateIndex("case_uid_unique", "case", true, "uid")
+		},
+		Down: func(m *Migrator) error {
+			return m.DropIndex("case_uid_unique", "case")
+		},
+	},
+	{
+		Version: 3,
+		Name:    "scoped case tokens",
+		Up:      migrateLegacyTokens,
+		Down: func(m *Migrator) error {
+			return fmt.Errorf("legacy case tokens cannot be restored, only their hash is stored")
+		},
+	},
+	{
+		Version: 4,
+		Name:    "content addressed blobs",
+		Up:      migrateBlobs,
+		Down: func(m *Migrator) error {
+			// Check first, restoring the files changes the storage for good.
+			if err := m.CanDropColumns(); err != nil {
+				return err
+			}
+			if err := restoreFileKeys(m); err != nil {
+				return err
+			}
+			if err := m.DropColumn("file", "blob_id"); err != nil {
+				return err
+			}
+			return m.DropTables("blob")
+		},
+	},
+	{
+		Version: 5,
+		Name:    "case lifecycle",
+		Up:      migrateCaseLifecycle,
+		Down: func(m *Migrator) error {
+			if err := m.DropTables("case_transition"); err != nil {
+				return err
+			}
+			return m.DropColumn("case", "status")
+		},
+	},
+	{
+		Version: 6,
+		Name:    "case comments",
+		Up: func(m *Migrator) error {
+			err := m.CreateTable("comment",
+				m.Column("id", m.PrimaryKey()),
+				m.Column("case_id", "integer NOT NULL"),
+				m.Column("author", "varchar(128) NOT NULL"),
+				m.Column("body", "text NOT NULL"),
+				m.Column("file_id", "integer NOT NULL DEFAULT 0"),
+				m.Column("path", "varchar(1024) NOT NULL DEFAULT ''"),
+				m.Column("created", m.DateTime()+" NOT NULL"),
+			)
+			if err != nil {
+				return err
+			}
+			return m.CreateIndex("comment_case_id", "comment", false, "case_id")
+		},
+		Down: func(m *Migrator) error {
+			return m.DropTables("comment")
+		},
+	},
+	{
+		Version: 7,
+		Name:    "case legal hold",
+		Up: func(m *Migrator) error {
+			return m.AddColumn("case", "legal_hold", "bool NOT NULL DEFAULT false")
+		},
+		Down: func(m *Migrator) error {
+			return m.DropColumn("case", "legal_hold")
+		},
+	},
+	{
+		Version: 8,
+		Name:    "file metadata",
+		Up:      migrateFileMetadata,
+		Down: func(m *Migrator) error {
+			for _, column := range fileMetadataColumns {
+				if err := m.DropColumn("file", column); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 9,
+		Name:    "report archive entries",
+		Up: func(m *Migrator) error {
+			err := m.CreateTable("entry",
+				m.Column("id", m.PrimaryKey()),
+				m.Column("blob_id", "integer NOT NULL"),
+				m.Column("path", "varchar(1024) NOT NULL"),
+				m.Column("size", "bigint NOT NULL DEFAULT 0"),
+				m.Column("type", "varchar(16) NOT NULL"),
+			)
+			if err != nil {
+				return err
+			}
+			return m.CreateIndex("entry_blob_id", "entry", false, "blob_id")
+		},
+		Down: func(m *Migrator) error {
+			return m.DropTables("entry")
+		},
+	},
+	{
+		Version: 10,
+		Name:    "report search index",
+		Up: func(m *Migrator) error {
+			err := m.CreateTable("posting",
+				m.Column("id", m.PrimaryKey()),
+				m.Column("term", "varchar(64) NOT NULL"),
+				m.Column("entry_id", "integer NOT NULL"),
+				m.Column("blob_id", "integer NOT NULL"),
+			)
+			if err != nil {
+				return err
+			}
+			if err := m.CreateIndex("posting_term", "posting", false, "term", "blob_id"); err != nil {
+				return err
+			}
+			return m.CreateIndex("posting_blob_id", "posting", false, "blob_id")
+		},
+		Down: func(m *Migrator) error {
+			return m.DropTables("posting")
+		},
+	},
+	{
+		Version: 11,
+		Name:    "webhooks",
+		Up: func(m *Migrator) error {
+			err := m.CreateTable("webhook",
+				m.Column("id", m.PrimaryKey()),
+				m.Column("url", "varchar(1024) NOT NULL"),
+				m.Column("secret", "varchar(64) NOT NULL"),
+				m.Column("events", "varchar(255) NOT NULL DEFAULT ''"),
+				m.Column("description", "varchar(255) NOT NULL DEFAULT ''"),
+				m.Column("active", "bool NOT NULL DEFAULT true"),
+				m.Column("created", m.DateTime()+" NOT NULL"),
+			)
+			if err != nil {
+				return err
+			}
+			err = m.CreateTable("webhook_delivery",
+				m.Column("id", m.PrimaryKey()),
+				m.Column("webhook_id", "integer NOT NULL"),
+				m.Column("event", "varchar(64) NOT NULL"),
+				m.Column("payload", "text NOT NULL"),
+				m.Column("status", "varchar(16) NOT NULL"),
+				m.Column("attempts", "integer NOT NULL DEFAULT 0"),
+				m.Column("response_code", "integer NOT NULL DEFAULT 0"),
+				m.Column("error", "text NOT NULL"),
+				m.Column("next_attempt", m.DateTime()+" NOT NULL"),
+				m.Column("created", m.DateTime()+" NOT NULL"),
+				m.Column("updated", m.DateTime()+" NOT NULL"),
+			)
+			if err != nil {
+				return err
+			}
+			if err := m.CreateIndex("webhook_delivery_webhook_id", "webhook_delivery", false, "webhook_id"); err != nil {
+				return err
+			}
+			return m.CreateIndex("webhook_delivery_status", "webhook_delivery", false, "status", "next_attempt")
+		},
+		Down: func(m *Migrator) error {
+			return m.DropTables("webhook_delivery", "webhook")
+		},
+	},
+	{
+		Version: 12,
+		Name:    "audit log",
+		Up: func(m *Migrator) error {
+			err := m.CreateTable("audit_event",
+				m.Column("id", m.PrimaryKey()),
+				m.Column("action", "varchar(32) NOT NULL"),
+				m.Column("actor", "varchar(128) NOT NULL"),
+				m.Column("remote_addr", "varchar(64) NOT NULL DEFAULT ''"),
+				m.Column("case", "varchar(36) NOT NULL DEFAULT ''"),
+				m.Column("file_id", "integer NOT NULL DEFAULT 0"),
+				m.Column("detail", "varchar(255) NOT NULL DEFAULT ''"),
+				m.Column("created", m.DateTime()+" NOT NULL"),
+				m.Column("prev_hash", "varchar(64) NOT NULL DEFAULT ''"),
+				m.Column("hash", "varchar(64) NOT NULL"),
+			)
+			if err != nil {
+				return err
+			}
+			for _, column := range []string{"action", "actor", "case", "created"} {
+				if err := m.CreateIndex("audit_event_"+column, "audit_event", false, column); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(m *Migrator) error {
+			return m.DropTables("audit_event")
+		},
+	},
+	{
+		Version: 13,
+		Name:    "private comments",
+		Up: func(m *Migrator) error {
+			return m.AddColumn("comment", "private", "bool NOT NULL DEFAULT false")
+		},
+		Down: func(m *Migrator) error {
+			return m.DropColumn("comment", "private")
+		},
+	},
+	{
+		Version: 14,
+		Name:    "blob indexed flag",
+		Up: func(m *Migrator) error {
+			if err := m.AddColumn("blob", "indexed", "bool NOT NULL DEFAULT false"); err != nil {
+				return err
+			}
+			// Blobs without entries are read once more and flagged then.
+			return m.Exec(fmt.Sprintf("UPDATE %s SET indexed = ? WHERE id IN (SELECT blob_id FROM %s)",
+				m.Quote("blob"), m.Quote("entry")), true)
+		},
+		Down: func(m *Migrator) error {
+			return m.DropColumn("blob", "indexed")
+		},
+	},
+	{
+		Version: 15,
+		Name:    "audit chain head",
+		Up: func(m *Migrator) error {
+			err := m.CreateTable("audit_head",
+				m.Column("id", "integer NOT NULL PRIMARY KEY"),
+				m.Column("hash", "varchar(64) NOT NULL DEFAULT ''"),
+			)
+			if err != nil {
+				return err
+			}
+			// The head continues the chain of the events already recorded.
+			return m.Exec(fmt.Sprintf("INSERT INTO audit_head (id, hash) "+
+				"SELECT %d, COALESCE((SELECT hash FROM audit_event ORDER BY id DESC LIMIT 1), '')", auditHeadId))
+		},
+		Down: func(m *Migrator) error {
+			return m.DropTables("audit_head")
+		},
+	},
+}
+
+var fileMetadataColumns = []string{"size", "digest", "content_type", "uploader", "hostname", "version"}
+
+func NewMigrator(config *Config) (*Migrator, error) {
+	storage, err := NewStorage(&config.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		Driver:     config.Database.Driver,
+		Storage:    storage,
+		Migrations: Migrations,
+		o:          orm.NewOrm(),
+	}, nil
+}
+
+func (m *Migrator) Exec(query string, args ...interface{}) error {
+	if _, err := m.o.Raw(query, args...).Exec(); err != nil {
+		return fmt.Errorf("%s: %s", err, query)
+	}
+	return nil
+}
+
+func (m *Migrator) Quote(name string) string {
+	if m.Driver == "mysql" {
+		return "`" + name + "`"
+	}
+	return `"` + name + `"`
+}
+
+func (m *Migrator) PrimaryKey() string {
+	switch m.Driver {
+	case "postgres":
+		return "serial NOT NULL PRIMARY KEY"
+	case "mysql":
+		return "integer AUTO_INCREMENT NOT NULL PRIMARY KEY"
+	}
+	return "integer NOT NULL PRIMARY KEY AUTOINCREMENT"
+}
+
+func (m *Migrator) DateTime() string {
+	if m.Driver == "postgres" {
+		return "timestamp with time zone"
+	}
+	return "datetime"
+}
+
+// Transactional reports whether schema changes can be rolled back. MySQL
+// commits implicitly on every DDL statement.
+func (m *Migrator) Transactional() bool {
+	return m.Driver != "mysql"
+}
+
+func (m *Migrator) CreateTable(table string, columns ...string) error {
+	return m.Exec(fmt.Sprintf("CREATE TABLE %s (\n\t%s\n)", m.Quote(table), strings.Join(columns, ",\n\t")))
+}
+
+func (m *Migrator) DropTables(tables ...string) error {
+	for _, table := range tables {
+		if err := m.Exec(fmt.Sprintf("DROP TABLE %s", m.Quote(table))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) Column(name string, definition string) string {
+	return fmt.Sprintf("%s %s", m.Quote(name), definition)
+}
+
+func (m *Migrator) AddColumn(table string, name string, definition string) error {
+	return m.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", m.Quote(table), m.Column(name, definition)))
+}
+
+func (m *Migrator) DropColumn(table string, name string) error {
+	if err := m.CanDropColumns(); err != nil {
+		return err
+	}
+	return m.Exec(fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", m.Quote(table), m.Quote(name)))
+}
+
+func (m *Migrator) CreateIndex(name string, table string, unique bool, columns ...string) error {
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = m.Quote(column)
+	}
+
+	kind := "INDEX"
+	if unique {
+		kind = "UNIQUE INDEX"
+	}
+
+	return m.Exec(fmt.Sprintf("CREATE %s %s ON %s (%s)", kind, m.Quote(name), m.Quote(table),
+		strings.Join(quoted, ", ")))
+}
+
+func (m *Migrator) DropIndex(name string, table string) error {
+	if m.Driver == "mysql" {
+		return m.Exec(fmt.Sprintf("DROP INDEX %s ON %s", m.Quote(name), m.Quote(table)))
+	}
+	return m.Exec(fmt.Sprintf("DROP INDEX %s", m.Quote(name)))
+}
+
+// CanDropColumns fails on SQLite releases without ALTER TABLE DROP COLUMN.
+// Reverts check it before changing anything outside the database.
+func (m *Migrator) CanDropColumns() error {
+	if m.Driver != "sqlite3" {
+		return nil
+	}
+
+	var version string
+	if err := m.o.Raw("SELECT sqlite_version()").QueryRow(&version); err != nil {
+		return err
+	}
+
+	var major, minor int
+	fmt.Sscanf(version, "%d.%d", &major, &minor)
+	if major < 3 || major == 3 && minor < 35 {
+		return fmt.Errorf("reverting needs SQLite 3.35 or later to drop columns, found %s", version)
+	}
+
+	return nil
+}
+
+// Rows runs query and returns every row as strings, NULL being empty.
+func (m *Migrator) Rows(query string, args ...interface{}) ([][]string, error) {
+	var lists []orm.ParamsList
+	if _, err := m.o.Raw(query, args...).ValuesList(&lists); err != nil {
+		return nil, err
+	}
+
+	rows := make([][]string, len(lists))
+	for i, list := range lists {
+		rows[i] = make([]string, len(list))
+		for j, value := range list {
+			if value != nil {
+				rows[i][j] = fmt.Sprint(value)
+			}
+		}
+	}
+
+	return rows, nil
+}
+
+// Columns returns the set of columns of table.
+func (m *Migrator) Columns(table string) (map[string]bool, error) {
+	column := 0
+	query := "SELECT column_name FROM information_schema.columns WHERE table_name = ?"
+	args := []interface{}{table}
+
+	switch m.Driver {
+	case "sqlite3":
+		// table_info returns cid, name, type, notnull, dflt_value, pk.
+		query = fmt.Sprintf("PRAGMA table_info(%s)", m.Quote(table))
+		args = nil
+		column = 1
+	case "postgres":
+		query = "SELECT column_name FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = ?"
+	case "mysql":
+		query = "SELECT column_name FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ?"
+	}
+
+	rows, err := m.Rows(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		columns[strings.ToLower(row[column])] = true
+	}
+
+	return columns, nil
+}
+
+func (m *Migrator) TableExists(table string) (bool, error) {
+	var count int
+
+	query := "SELECT COUNT(*) FROM information_schema.tables WHERE table_name = ?"
+	switch m.Driver {
+	case "sqlite3":
+		query = "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?"
+	case "postgres":
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?"
+	case "mysql":
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?"
+	}
+
+	if err := m.o.Raw(query, table).QueryRow(&count); err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func (m *Migrator) ensureVersionTable() error {
+	exists, err := m.TableExists("schema_migrations")
+	if err != nil || exists {
+		return err
+	}
+
+	return m.CreateTable("schema_migrations",
+		m.Column("version", "integer NOT NULL PRIMARY KEY"),
+		m.Column("name", "varchar(255) NOT NULL"),
+		m.Column("applied", m.DateTime()+" NOT NULL"),
+	)
+}
+
+func (m *Migrator) Status() ([]*MigrationStatus, error) {
+	if err := m.ensureVersionTable(); err != nil {
+		return nil, err
+	}
+
+	var applied []*SchemaMigration
+	if _, err := m.o.QueryTable("schema_migrations").OrderBy("Version").All(&applied); err != nil {
+		return nil, err
+	}
+
+	versions := make(map[int]*SchemaMigration, len(applied))
+	for _, a := range applied {
+		versions[a.Version] = a
+	}
+
+	status := make([]*MigrationStatus, len(m.Migrations))
+	for i, migration := range m.Migrations {
+		status[i] = &MigrationStatus{Migration: migration, Applied: versions[migration.Version]}
+	}
+
+	return status, nil
+}
+
+func (m *Migrator) Pending() ([]*Migration, error) {
+	status, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []*Migration
+	for _, s := range status {
+		if s.Applied == nil {
+			pending = append(pending, s.Migration)
+		}
+	}
+
+	return pending, nil
+}
+
+func (m *Migrator) run(migration *Migration, step func(m *Migrator) error, record func() error) error {
+	if m.Transactional() {
+		if err := m.o.Begin(); err != nil {
+			return err
+		}
+	}
+
+	err := step(m)
+	if err == nil {
+		err = record()
+	}
+
+	if err != nil {
+		if m.Transactional() {
+			m.o.Rollback()
+		}
+		return fmt.Errorf("migration %d (%s) failed: %s", migration.Version, migration.Name, err)
+	}
+
+	if m.Transactional() {
+		return m.o.Commit()
+	}
+
+	return nil
+}
+
+// Up applies every pending migration in order.
+func (m *Migrator) Up() ([]*Migration, error) {
+	pending, err := m.Pending()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, migration := range pending {
+		err := m.run(migration, migration.Up, func() error {
+			_, err := m.o.Insert(&SchemaMigration{
+				Version: migration.Version,
+				Name:    migration.Name,
+				Applied: time.Now(),
+			})
+			return err
+		})
+
+		if err != nil {
+			return pending[:i], err
+		}
+
+		log.Printf("applied migration %d: %s", migration.Version, migration.Name)
+	}
+
+	return pending, nil
+}
+
+// Down reverts the most recently applied migration.
+func (m *Migrator) Down() (*Migration, error) {
+	status, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(status) - 1; i >= 0; i-- {
+		if status[i].Applied == nil {
+			continue
+		}
+
+		migration := status[i].Migration
+		err := m.run(migration, migration.Down, func() error {
+			_, err := m.o.Delete(&SchemaMigration{Version: migration.Version})
+			return err
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		log.Printf("reverted migration %d: %s", migration.Version, migration.Name)
+		return migration, nil
+	}
+
+	return nil, fmt.Errorf("no migrations to revert")
+}
+
+type schemaColumn struct {
+	Name       string
+	Definition string
+}
+
+type schemaTable struct {
+	Name    string
+	Columns []schemaColumn
+}
+
+// initialSchema is the schema as of the first versioned release.
+func initialSchema(m *Migrator) []schemaTable {
+	return []schemaTable{
+		{"case", []schemaColumn{
+			{"id", m.PrimaryKey()},
+			{"uid", "varchar(36) NOT NULL DEFAULT ''"},
+			{"description", "text NOT NULL"},
+			{"created", m.DateTime() + " NOT NULL"},
+			{"updated", m.DateTime() + " NOT NULL"},
+			{"is_signed", "bool NOT NULL DEFAULT false"},
+			{"is_private", "bool NOT NULL DEFAULT false"},
+			{"token", "varchar(255) NOT NULL DEFAULT ''"},
+			{"config", "text NOT NULL"},
+			{"signed", "text NOT NULL"},
+			{"envelope", "text NOT NULL"},
+			{"owner_id", "integer"},
+			{"team", "varchar(128) NOT NULL DEFAULT ''"},
+		}},
+		{"file", []schemaColumn{
+			{"id", m.PrimaryKey()},
+			{"path", "varchar(255) NOT NULL DEFAULT ''"},
+			{"created", m.DateTime() + " NOT NULL"},
+			{"case_id", "integer NOT NULL"},
+		}},
+		{"user", []schemaColumn{
+			{"id", m.PrimaryKey()},
+			{"name", "varchar(128) NOT NULL UNIQUE"},
+			{"role", "varchar(16) NOT NULL DEFAULT ''"},
+			{"team", "varchar(128) NOT NULL DEFAULT ''"},
+			{"api_key", "varchar(64) NOT NULL UNIQUE"},
+			{"created", m.DateTime() + " NOT NULL"},
+		}},
+		{"invitation", []schemaColumn{
+			{"id", m.PrimaryKey()},
+			{"case_id", "integer NOT NULL"},
+			{"user_id", "integer NOT NULL"},
+			{"created", m.DateTime() + " NOT NULL"},
+		}},
+		{"token", []schemaColumn{
+			{"id", m.PrimaryKey()},
+			{"case_id", "integer NOT NULL"},
+			{"secret", "varchar(64) NOT NULL UNIQUE"},
+			{"scopes", "varchar(64) NOT NULL DEFAULT ''"},
+			{"description", "varchar(255) NOT NULL DEFAULT ''"},
+			{"expires", m.DateTime() + " NOT NULL"},
+			{"max_uses", "integer NOT NULL DEFAULT 0"},
+			{"uses", "integer NOT NULL DEFAULT 0"},
+			{"revoked", "bool NOT NULL DEFAULT false"},
+			{"created", m.DateTime() + " NOT NULL"},
+		}},
+	}
+}
+
+// migrateInitialSchema creates the tables as of the first versioned release.
+// Databases created earlier through syncdb are adopted: the tables and
+// columns added by releases they predate are created.
+func migrateInitialSchema(m *Migrator) error {
+	for _, table := range initialSchema(m) {
+		exists, err := m.TableExists(table.Name)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			columns := make([]string, len(table.Columns))
+			for i, column := range table.Columns {
+				columns[i] = m.Column(column.Name, column.Definition)
+			}
+
+			if err := m.CreateTable(table.Name, columns...); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := m.adoptTable(table); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// adoptTable adds the columns missing from an existing table. Columns that
+// are mandatory without a default are added nullable and filled in.
+func (m *Migrator) adoptTable(table schemaTable) error {
+	existing, err := m.Columns(table.Name)
+	if err != nil {
+		return err
+	}
+
+	var added []string
+	for _, column := range table.Columns {
+		if existing[column.Name] {
+			continue
+		}
+
+		definition := column.Definition
+		if strings.Contains(definition, "NOT NULL") && !strings.Contains(definition, "DEFAULT") {
+			definition = strings.Replace(definition, " NOT NULL", "", 1)
+			definition = strings.Replace(definition, " UNIQUE", "", 1)
+		}
+
+		if err := m.AddColumn(table.Name, column.Name, definition); err != nil {
+			return err
+		}
+
+		var fill interface{}
+		switch {
+		case strings.HasPrefix(definition, "text"), strings.HasPrefix(definition, "varchar"):
+			fill = ""
+		case strings.HasPrefix(definition, m.DateTime()):
+			fill = time.Now()
+		}
+
+		if fill != nil {
+			err := m.Exec(fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s IS NULL", m.Quote(table.Name),
+				m.Quote(column.Name), m.Quote(column.Name)), fill)
+			if err != nil {
+				return err
+			}
+		}
+
+		added = append(added, column.Name)
+	}
+
+	if len(added) > 0 {
+		log.Printf("adopted existing %s table, added columns: %s", table.Name, strings.Join(added, ", "))
+	}
+
+	return nil
+}
+
+// migrateCaseIdentifiers gives a public identifier to the cases created
+// before they existed, so they stay reachable through the API.
+func migrateCaseIdentifiers(m *Migrator) error {
+	rows, err := m.Rows(fmt.Sprintf("SELECT id FROM %s WHERE uid = '' OR uid IS NULL", m.Quote("case")))
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		if err := m.Exec(fmt.Sprintf("UPDATE %s SET uid = ? WHERE id = ?", m.Quote("case")), uuid.New(), row[0]); err != nil {
+			return err
+		}
+	}
+
+	if len(rows) > 0 {
+		log.Printf("assigned public identifiers to %d existing cases", len(rows))
+	}
+
+	return nil
+}
+
+// migrateLegacyTokens turns the single token of older private cases into a
+// scoped token. It keeps never expiring, so links already handed to
+// customers keep working.
+func migrateLegacyTokens(m *Migrator) error {
+	rows, err := m.Rows(fmt.Sprintf("SELECT id, token FROM %s WHERE token <> '' AND token IS NOT NULL", m.Quote("case")))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, row := range rows {
+		err := m.Exec(fmt.Sprintf("INSERT INTO %s (case_id, secret, scopes, description, expires, created) "+
+			"VALUES (?, ?, ?, ?, ?, ?)", m.Quote("token")),
+			row[0], HashSecret(row[1]), "read,upload,download", "migrated legacy case token", NeverExpires, now)
+		if err != nil {
+			return err
+		}
+
+		if err := m.Exec(fmt.Sprintf("UPDATE %s SET token = '' WHERE id = ?", m.Quote("case")), row[0]); err != nil {
+			return err
+		}
+	}
+
+	if len(rows) > 0 {
+		log.Printf("migrated %d legacy case tokens, they do not expire: revoke them to end access", len(rows))
+	}
+
+	return nil
+}
+
+func migrateBlobs(m *Migrator) error {
+	err := m.CreateTable("blob",
+		m.Column("id", m.PrimaryKey()),
+		m.Column("digest", "varchar(64) NOT NULL UNIQUE"),
+		m.Column("size", "bigint NOT NULL DEFAULT 0"),
+		m.Column("ref_count", "integer NOT NULL DEFAULT 0"),
+		m.Column("created", m.DateTime()+" NOT NULL"),
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := m.AddColumn("file", "blob_id", "integer"); err != nil {
+		return err
+	}
+
+	return migrateFileBlobs(m)
+}
+
+// legacyFileKey is where files were stored before blobs existed.
+func legacyFileKey(caseId string, filename string) string {
+	return path.Join(caseId, filename)
+}
+
+// migrateFileBlobs moves the files stored per case into content addressed
+// blobs. Files missing from the storage are left without a blob.
+func migrateFileBlobs(m *Migrator) error {
+	rows, err := m.Rows(fmt.Sprintf("SELECT id, path, case_id FROM %s WHERE blob_id IS NULL", m.Quote("file")))
+	if err != nil {
+		return err
+	}
+
+	var moved []string
+	for _, row := range rows {
+		key := legacyFileKey(row[2], row[1])
+
+		data, err := m.readObject(key)
+		if err == ErrStorageNotFound {
+			log.Printf("file %s of case %s is missing from the storage, skipping", row[1], row[2])
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		digest := Digest(data)
+		blobs, err := m.Rows(fmt.Sprintf("SELECT id FROM %s WHERE digest = ?", m.Quote("blob")), digest)
+		if err != nil {
+			return err
+		}
+
+		if len(blobs) == 0 {
+			if err := m.Storage.Put(BlobKey(digest), bytes.NewReader(data), int64(len(data))); err != nil {
+				return err
+			}
+
+			err := m.Exec(fmt.Sprintf("INSERT INTO %s (digest, size, ref_count, created) VALUES (?, ?, 1, ?)",
+				m.Quote("blob")), digest, len(data), time.Now())
+			if err != nil {
+				return err
+			}
+
+			if blobs, err = m.Rows(fmt.Sprintf("SELECT id FROM %s WHERE digest = ?", m.Quote("blob")), digest); err != nil {
+				return err
+			}
+		} else {
+			if err := m.Exec(fmt.Sprintf("UPDATE %s SET ref_count = ref_count + 1 WHERE id = ?", m.Quote("blob")), blobs[0][0]); err != nil {
+				return err
+			}
+		}
+
+		if err := m.Exec(fmt.Sprintf("UPDATE %s SET blob_id = ? WHERE id = ?", m.Quote("file")), blobs[0][0], row[0]); err != nil {
+			return err
+		}
+
+		moved = append(moved, key)
+	}
+
+	// The old copies go last, the database changes may still roll back.
+	for _, key := range moved {
+		if err := m.Storage.Delete(key); err != nil && err != ErrStorageNotFound {
+			log.Printf("cannot remove %s after moving it to a blob: %s", key, err)
+		}
+	}
+
+	return nil
+}
+
+// restoreFileKeys copies every file back from its blob to where it was
+// stored before blobs existed.
+func restoreFileKeys(m *Migrator) error {
+	rows, err := m.Rows(fmt.Sprintf("SELECT f.path, f.case_id, b.digest FROM %s f JOIN %s b ON b.id = f.blob_id",
+		m.Quote("file"), m.Quote("blob")))
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		data, err := m.readObject(BlobKey(row[2]))
+		if err != nil {
+			return fmt.Errorf("cannot restore file %s of case %s: %s", row[0], row[1], err)
+		}
+
+		if err := m.Storage.Put(legacyFileKey(row[1], row[0]), bytes.NewReader(data), int64(len(data))); err != nil {
+			return err
+		}
+	}
+
+	digests, err := m.Rows(fmt.Sprintf("SELECT digest FROM %s", m.Quote("blob")))
+	if err != nil {
+		return err
+	}
+
+	for _, row := range digests {
+		if err := m.Storage.Delete(BlobKey(row[0])); err != nil && err != ErrStorageNotFound {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *Migrator) readObject(key string) ([]byte, error) {
+	content, err := m.Storage.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	return ioutil.ReadAll(content)
+}
+
+func migrateCaseLifecycle(m *Migrator) error {
+	if err := m.AddColumn("case", "status", "varchar(32) NOT NULL DEFAULT 'open'"); err != nil {
+		return err
+	}
+
+	err := m.CreateTable("case_transition",
+		m.Column("id", m.PrimaryKey()),
+		m.Column("case_id", "integer NOT NULL"),
+		m.Column("from_status", "varchar(32) NOT NULL"),
+		m.Column("to_status", "varchar(32) NOT NULL"),
+		m.Column("actor", "varchar(128) NOT NULL DEFAULT ''"),
+		m.Column("reason", "varchar(255) NOT NULL DEFAULT ''"),
+		m.Column("created", m.DateTime()+" NOT NULL"),
+	)
+	if err != nil {
+		return err
+	}
+
+	return m.CreateIndex("case_transition_case_id", "case_transition", false, "case_id")
+}
+
+func migrateFileMetadata(m *Migrator) error {
+	definitions := map[string]string{
+		"size":         "bigint NOT NULL DEFAULT 0",
+		"digest":       "varchar(64) NOT NULL DEFAULT ''",
+		"content_type": "varchar(128) NOT NULL DEFAULT ''",
+		"uploader":     "varchar(128) NOT NULL DEFAULT ''",
+		"hostname":     "varchar(255) NOT NULL DEFAULT ''",
+		"version":      "varchar(32) NOT NULL DEFAULT ''",
+	}
+
+	for _, column := range fileMetadataColumns {
+		if err := m.AddColumn("file", column, definitions[column]); err != nil {
+			return err
+		}
+	}
+
+	// Files already moved to blobs take their size and digest from there.
+	blob := func(column string) string {
+		return fmt.Sprintf("(SELECT %s FROM %s WHERE %s.id = %s.blob_id)", m.Quote(column), m.Quote("blob"),
+			m.Quote("blob"), m.Quote("file"))
+	}
+
+	return m.Exec(fmt.Sprintf("UPDATE %s SET size = %s, digest = %s WHERE blob_id IS NOT NULL AND digest = ''",
+		m.Quote("file"), blob("size"), blob("digest")))
+}
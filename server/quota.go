@@ -0,0 +1,177 @@
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/astaxie/beego/orm"
+	"github.com/emicklei/go-restful"
+	"net/http"
+	"strings"
+)
+
+const (
+	DefaultMaxUploadSize = 256 * 1024 * 1024
+
+	// StatusInsufficientStorage is the WebDAV status for a full quota.
+	StatusInsufficientStorage = 507
+
+	// uploadOverhead covers the JSON around the encoded file contents.
+	uploadOverhead = 64 * 1024
+)
+
+// QuotaConfig limits the size of a single upload and the total size of the
+// files of a case and of all the cases of an owner. Zero quotas are unlimited.
+type QuotaConfig struct {
+	MaxUploadSize int64 `yaml:"max_upload_size"`
+	CaseBytes     int64 `yaml:"case_bytes"`
+	OwnerBytes    int64 `yaml:"owner_bytes"`
+}
+
+// Usage reports the storage used by a case and its owner with their limits.
+type Usage struct {
+	CaseBytes     int64
+	CaseQuota     int64
+	OwnerBytes    int64 `json:",omitempty"`
+	OwnerQuota    int64 `json:",omitempty"`
+	MaxUploadSize int64
+}
+
+// MaxBodySize is the largest request body accepted for an upload, the file
+// being base64 encoded in a JSON document.
+func (q *QuotaConfig) MaxBodySize() int64 {
+	return int64(base64.StdEncoding.EncodedLen(int(q.MaxUploadSize))) + uploadOverhead
+}
+
+// sumBlobSizes adds the size of the blob of every file matching qs, counting
+// shared blobs once per file.
+func sumBlobSizes(o orm.Ormer, qs orm.QuerySeter) (int64, error) {
+	var blobIds orm.ParamsList
+
+	if _, err := qs.Filter("Blob__isnull", false).Limit(-1).ValuesFlat(&blobIds, "Blob"); err != nil {
+		return 0, err
+	}
+
+	if len(blobIds) == 0 {
+		return 0, nil
+	}
+
+	sizes := make(map[string]int64)
+	for _, chunk := range ChunkIds([]interface{}(blobIds)) {
+		var blobs []*Blob
+		if _, err := o.QueryTable("blob").Filter("Id__in", chunk...).Limit(-1).All(&blobs, "Id", "Size"); err != nil {
+			return 0, err
+		}
+
+		for _, blob := range blobs {
+			sizes[fmt.Sprint(blob.Id)] = blob.Size
+		}
+	}
+
+	var total int64
+	for _, id := range blobIds {
+		total += sizes[fmt.Sprint(id)]
+	}
+
+	return total, nil
+}
+
+func CaseUsage(o orm.Ormer, quota *QuotaConfig, c *Case) (*Usage, error) {
+	var err error
+
+	usage := &Usage{CaseQuota: quota.CaseBytes, MaxUploadSize: quota.MaxUploadSize}
+
+	usage.CaseBytes, err = sumBlobSizes(o, o.QueryTable("file").Filter("Case", c.Id))
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Owner != nil {
+		usage.OwnerQuota = quota.OwnerBytes
+		usage.OwnerBytes, err = sumBlobSizes(o, o.QueryTable("file").Filter("Case__Owner", c.Owner.Id))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return usage, nil
+}
+
+// SeesOwnerUsage tells whether user may see the storage used by all the cases
+// of the owner of c: only that owner and admins may.
+func SeesOwnerUsage(user *User, c *Case) bool {
+	if user == nil || c.Owner == nil {
+		return false
+	}
+	return user.Role == RoleAdmin || user.Id == c.Owner.Id
+}
+
+// LockQuota holds the rows of c and of its owner until the end of the current
+// transaction, so that concurrent uploads are checked against the quotas one
+// after the other.
+func LockQuota(o orm.Ormer, quota *QuotaConfig, c *Case) error {
+	if quota.OwnerBytes > 0 && c.Owner != nil {
+		if _, err := o.QueryTable("user").Filter("Id", c.Owner.Id).Update(orm.Params{"Id": c.Owner.Id}); err != nil {
+			return err
+		}
+	}
+
+	if quota.CaseBytes > 0 {
+		if _, err := o.QueryTable("case").Filter("Id", c.Id).Update(orm.Params{"Id": c.Id}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CheckQuota tells whether size more bytes fit in the quotas of the case,
+// returning the HTTP status and message to reject the upload with.
+func CheckQuota(o orm.Ormer, quota *QuotaConfig, c *Case, size int64) (int, error) {
+	if quota.MaxUploadSize > 0 && size > quota.MaxUploadSize {
+		return http.StatusRequestEntityTooLarge,
+			fmt.Errorf("file is %d bytes, the maximum upload size is %d bytes", size, quota.MaxUploadSize)
+	}
+
+	if quota.CaseBytes == 0 && quota.OwnerBytes == 0 {
+		return 0, nil
+	}
+
+	usage, err := CaseUsage(o, quota, c)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	if quota.CaseBytes > 0 && usage.CaseBytes+size > quota.CaseBytes {
+		return StatusInsufficientStorage, fmt.Errorf("case quota exceeded: %d of %d bytes used, %d bytes uploaded",
+			usage.CaseBytes, quota.CaseBytes, size)
+	}
+
+	if usage.OwnerQuota > 0 && usage.OwnerBytes+size > usage.OwnerQuota {
+		return StatusInsufficientStorage, fmt.Errorf("owner quota exceeded: %d of %d bytes used, %d bytes uploaded",
+			usage.OwnerBytes, usage.OwnerQuota, size)
+	}
+
+	return 0, nil
+}
+
+// LimitUpload rejects uploads announcing a body larger than allowed and caps
+// the body of the others, before anything is read.
+func LimitUpload(quota *QuotaConfig, request *restful.Request, response *restful.Response) bool {
+	if quota.MaxUploadSize <= 0 {
+		return true
+	}
+
+	max := quota.MaxBodySize()
+	if request.Request.ContentLength > max {
+		response.WriteErrorString(http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("upload is larger than the maximum upload size of %d bytes", quota.MaxUploadSize))
+		return false
+	}
+
+	request.Request.Body = http.MaxBytesReader(response.ResponseWriter, request.Request.Body, max)
+	return true
+}
+
+func IsBodyTooLarge(err error) bool {
+	return strings.Contains(err.Error(), "request body too large")
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestSeesOwnerUsage(t *testing.T) {
+	owner := &User{Id: 1, Name: "jane", Role: RoleEngineer}
+	c := &Case{Id: 1, Owner: owner}
+
+	tests := []struct {
+		user *User
+		c    *Case
+		sees bool
+	}{
+		{owner, c, true},
+		{&User{Id: 2, Name: "root", Role: RoleAdmin}, c, true},
+		{&User{Id: 3, Name: "john", Role: RoleEngineer, Team: "support"}, c, false},
+		{&User{Id: 4, Name: "acme", Role: RoleCustomer}, c, false},
+		{nil, c, false},
+		{owner, &Case{Id: 2}, false},
+	}
+
+	for _, test := range tests {
+		if got := SeesOwnerUsage(test.user, test.c); got != test.sees {
+			t.Errorf("%+v on case %d: got %t, want %t", test.user, test.c.Id, got, test.sees)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package server
+
+import (
+	"fmt"
+	"github.com/astaxie/beego/orm"
+	"github.com/emicklei/go-restful"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultReapInterval = time.Hour
+)
+
+var ErrLegalHold = fmt.Errorf("case is under legal hold")
+
+// RetentionConfig sets how long data of closed cases is kept. Reports are the
+// uploaded files, a case is everything including its history. Zero keeps data
+// forever.
+type RetentionConfig struct {
+	ReportDays int    `yaml:"report_days"`
+	CaseDays   int    `yaml:"case_days"`
+	Interval   string `yaml:"interval"`
+}
+
+type HoldRequest struct {
+	Hold bool
+}
+
+func (r *RetentionConfig) Enabled() bool {
+	return r.ReportDays > 0 || r.CaseDays > 0
+}
+
+func (r *RetentionConfig) ReapInterval() (time.Duration, error) {
+	if r.Interval == "" {
+		return DefaultReapInterval, nil
+	}
+
+	interval, err := time.ParseDuration(r.Interval)
+	if err != nil || interval <= 0 {
+		return 0, fmt.Errorf("invalid retention interval: %s", r.Interval)
+	}
+
+	return interval, nil
+}
+
+// DeleteFile removes file and records event in a single transaction, and
+// its object from the storage once committed.
+func DeleteFile(o orm.Ormer, storage Storage, file *File, event *AuditEvent) error {
+	var released []*Blob
+
+	err := InTransaction(o, func() error {
+		if _, err := o.Delete(file); err != nil {
+			return err
+		}
+
+		if file.Blob != nil {
+			last, err := ReleaseBlob(o, file.Blob)
+			if err != nil {
+				return err
+			}
+
+			if last {
+				released = append(released, file.Blob)
+			}
+		}
+
+		return AppendAudit(o, event)
+	})
+	if err != nil {
+		return err
+	}
+
+	RemoveBlobObjects(o, storage, released)
+	return nil
+}
+
+// deleteFileRecords removes the files of c from the database and returns the
+// blobs they were the last to reference.
+func deleteFileRecords(o orm.Ormer, c *Case) (int, []*Blob, error) {
+	var files []*File
+	var released []*Blob
+
+	if _, err := o.QueryTable("file").Filter("Case", c.Id).All(&files, "Id", "Blob"); err != nil {
+		return 0, nil, err
+	}
+
+	for _, file := range files {
+		if _, err := o.Delete(file); err != nil {
+			return 0, nil, err
+		}
+
+		if file.Blob == nil {
+			continue
+		}
+
+		last, err := ReleaseBlob(o, file.Blob)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if last {
+			released = append(released, file.Blob)
+		}
+	}
+
+	return len(files), released, nil
+}
+
+// DeleteFiles removes the files of c in a single transaction, and their
+// objects from the storage once committed. When files were deleted, event is
+// recorded in the transaction with their number as detail.
+func DeleteFiles(o orm.Ormer, storage Storage, c *Case, event *AuditEvent) (int, error) {
+	var deleted int
+	var released []*Blob
+
+	err := InTransaction(o, func() error {
+		var err error
+		deleted, released, err = deleteFileRecords(o, c)
+		if err != nil || deleted == 0 {
+			return err
+		}
+
+		event.Detail = fmt.Sprintf("%d reports", deleted)
+		return AppendAudit(o, event)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	RemoveBlobObjects(o, storage, released)
+	return deleted, nil
+}
+
+// DeleteCase removes a case with its files, tokens, invitations, history and
+// comments, and records event, in a single transaction. Cases under legal
+// hold are kept. The file contents are removed from the storage once
+// committed.
+func DeleteCase(o orm.Ormer, storage Storage, c *Case, event *AuditEvent) error {
+	if c.LegalHold {
+		return ErrLegalHold
+	}
+
+	var released []*Blob
+
+	err := InTransaction(o, func() error {
+		// A hold may have been set since the case was loaded.
+		if o.QueryTable("case").Filter("Id", c.Id).Filter("LegalHold", true).Exist() {
+			return ErrLegalHold
+		}
+
+		var err error
+		if _, released, err = deleteFileRecords(o, c); err != nil {
+			return err
+		}
+
+		for _, table := range []string{"token", "invitation", "case_transition", "comment"} {
+			if _, err := o.QueryTable(table).Filter("Case", c.Id).Delete(); err != nil {
+				return err
+			}
+		}
+
+		if _, err := o.Delete(c); err != nil {
+			return err
+		}
+
+		return AppendAudit(o, event)
+	})
+	if err != nil {
+		return err
+	}
+
+	RemoveBlobObjects(o, storage, released)
+	return nil
+}
+
+// ClosedAt returns when c was last closed, falling back to its last update
+// for cases closed before transitions were recorded.
+func ClosedAt(o orm.Ormer, c *Case) time.Time {
+	var transition CaseTransition
+
+	err := o.QueryTable("case_transition").Filter("Case", c.Id).Filter("ToStatus", StatusClosed).
+		OrderBy("-Id").Limit(1).One(&transition)
+	if err != nil {
+		return c.Updated
+	}
+
+	return transition.Created
+}
+
+// Reap applies the retention policy to the closed and archived cases that are
+// not under legal hold.
+func Reap(o orm.Ormer, storage Storage, retention *RetentionConfig, now time.Time) error {
+	var cases []*Case
+
+	_, err := o.QueryTable("case").Filter("Status__in", StatusClosed, StatusArchived).
+		Filter("LegalHold", false).All(&cases, "Id", "Uid", "Updated", "Status", "LegalHold")
+	if err != nil {
+		return err
+	}
+
+	day := 24 * time.Hour
+
+	for _, c := range cases {
+		closed := ClosedAt(o, c)
+
+		if retention.CaseDays > 0 && now.Sub(closed) > time.Duration(retention.CaseDays)*day {
+			event := &AuditEvent{Action: AuditCasePurge, Actor: "retention", Case: c.Uid}
+			if err := DeleteCase(o, storage, c, event); err != nil {
+				log.Printf("cannot purge case %s: %s", c.Uid, err)
+				continue
+			}
+			log.Printf("purged case %s closed on %s", c.Uid, closed.Format(time.RFC3339))
+			continue
+		}
+
+		if retention.ReportDays > 0 && now.Sub(closed) > time.Duration(retention.ReportDays)*day {
+			event := &AuditEvent{Action: AuditFilePurge, Actor: "retention", Case: c.Uid}
+			deleted, err := DeleteFiles(o, storage, c, event)
+			if err != nil {
+				log.Printf("cannot purge reports of case %s: %s", c.Uid, err)
+				continue
+			}
+			if deleted > 0 {
+				log.Printf("purged %d reports of case %s closed on %s", deleted, c.Uid, closed.Format(time.RFC3339))
+			}
+		}
+	}
+
+	return nil
+}
+
+// StartReaper runs Reap in the background every retention interval.
+func StartReaper(storage Storage, retention *RetentionConfig) error {
+	interval, err := retention.ReapInterval()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			if err := Reap(orm.NewOrm(), storage, retention, time.Now()); err != nil {
+				log.Printf("retention reaper failed: %s", err)
+			}
+			time.Sleep(interval)
+		}
+	}()
+
+	return nil
+}
+
+func (handler *CaseHandler) Delete(request *restful.Request, response *restful.Response) {
+	c := CurrentCase(request)
+	o := orm.NewOrm()
+
+	err := DeleteCase(o, handler.Storage, c, NewAuditEvent(request, AuditCaseDelete, c, 0, ""))
+	if err == ErrLegalHold {
+		response.WriteErrorString(http.StatusConflict, err.Error())
+		return
+	} else if err != nil {
+		response.WriteErrorString(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.WriteHeader(http.StatusNoContent)
+}
+
+func (handler *CaseHandler) DeleteFile(request *restful.Request, response *restful.Response) {
+	c := CurrentCase(request)
+	o := orm.NewOrm()
+
+	if c.LegalHold {
+		response.WriteErrorString(http.StatusConflict, ErrLegalHold.Error())
+		return
+	}
+
+	file_id, err := strconv.Atoi(request.PathParameter("file-id"))
+	if err != nil {
+		response.WriteErrorString(http.StatusBadRequest, "invalid provided file id")
+		return
+	}
+
+	file := File{Id: file_id}
+	if err := o.Read(&file); err != nil || file.Case == nil || file.Case.Id != c.Id {
+		response.WriteErrorString(http.StatusNotFound, "not found specified file")
+		return
+	}
+
+	event := NewAuditEvent(request, AuditFileDelete, c, file.Id, file.Path)
+	if err := DeleteFile(o, handler.Storage, &file, event); err != nil {
+		response.WriteErrorString(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.WriteHeader(http.StatusNoContent)
+}
+
+func (handler *CaseHandler) SetHold(request *restful.Request, response *restful.Response) {
+	c := CurrentCase(request)
+
+	h := new(HoldRequest)
+	if err := request.ReadEntity(h); err != nil {
+		response.WriteErrorString(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	detail := "hold released"
+	if h.Hold {
+		detail = "hold set"
+	}
+
+	o := orm.NewOrm()
+	c.LegalHold = h.Hold
+
+	err := InTransaction(o, func() error {
+		if _, err := o.Update(c, "LegalHold"); err != nil {
+			return err
+		}
+		return AppendAudit(o, NewAuditEvent(request, AuditCaseHold, c, 0, detail))
+	})
+	if err != nil {
+		response.WriteErrorString(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	log.Printf("legal hold of case %s set to %t by %s", c.Uid, c.LegalHold, CurrentUser(request).Name)
+	response.WriteEntity(c)
+}
@@ -0,0 +1,19 @@
+package server
+
+import (
+	"testing"
+)
+
+// TestRoutesAuthorized makes sure no route is reachable without declaring the
+// action it needs.
+func TestRoutesAuthorized(t *testing.T) {
+	container := NewContainer(&Config{}, nil)
+
+	for _, ws := range container.RegisteredWebServices() {
+		for _, route := range ws.Routes() {
+			if len(route.Filters) == 0 {
+				t.Errorf("%s %s has no Authorize filter", route.Method, route.Path)
+			}
+		}
+	}
+}
@@ -0,0 +1,529 @@
+package server
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/astaxie/beego/orm"
+	"github.com/emicklei/go-restful"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	MaxIndexedEntrySize = 8 * 1024 * 1024
+	MaxTermLength       = 64
+	MinTermLength       = 2
+
+	DefaultSearchLimit = 200
+	MaxSearchLimit     = 1000
+
+	// MaxSearchScan bounds the entries read by a search. Only whole word
+	// searches use the index, substrings and regular expressions read every
+	// entry.
+	MaxSearchScan = 2000
+)
+
+// Posting records that a term appears in an archive entry. It is the
+// inverted index used by search, built when a report is uploaded.
+type Posting struct {
+	Id    int    `orm:"auto"`
+	Term  string `orm:"size(64);index"`
+	Entry *Entry `orm:"rel(fk)"`
+	Blob  *Blob  `orm:"rel(fk)"`
+}
+
+type SearchMatch struct {
+	Case   string
+	FileId int
+	File   string
+	Entry  string
+	Line   int
+	Text   string
+}
+
+// SearchResponse holds the matching lines. Indexing counts the reports not
+// indexed yet, which are not searched.
+type SearchResponse struct {
+	Matches   []*SearchMatch
+	Truncated bool
+	Indexing  int `json:",omitempty"`
+}
+
+// Terms splits text into the distinct lowercase words the index stores.
+func Terms(text string) []string {
+	seen := make(map[string]bool)
+
+	var terms []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	}) {
+		if len(word) < MinTermLength || len(word) > MaxTermLength || seen[word] {
+			continue
+		}
+
+		seen[word] = true
+		terms = append(terms, word)
+	}
+
+	return terms
+}
+
+func IsText(data []byte) bool {
+	if len(data) > 8192 {
+		data = data[:8192]
+	}
+	return bytes.IndexByte(data, 0) < 0
+}
+
+// IndexContents adds the words of every text entry of an archive blob to the
+// inverted index. The entries must have been recorded already.
+func IndexContents(o orm.Ormer, blob *Blob, data []byte) error {
+	var entries []*Entry
+	if _, err := o.QueryTable("entry").Filter("Blob", blob.Id).Filter("Type", "file").Limit(-1).All(&entries); err != nil {
+		return err
+	}
+
+	ids := make(map[string]*Entry, len(entries))
+	for _, entry := range entries {
+		ids[entry.Path] = entry
+	}
+
+	archive, err := openArchive(bytes.NewReader(data))
+	if err != nil || archive == nil {
+		return err
+	}
+
+	for {
+		// Invalid archives keep the words indexed so far.
+		header, err := archive.Next()
+		if err != nil {
+			return nil
+		}
+
+		entry := ids[EntryPath(header.Name)]
+		if entry == nil || header.Size > MaxIndexedEntrySize {
+			continue
+		}
+
+		content, err := ioutil.ReadAll(archive)
+		if err != nil {
+			return nil
+		}
+
+		if !IsText(content) {
+			continue
+		}
+
+		var postings []*Posting
+		for _, term := range Terms(string(content)) {
+			postings = append(postings, &Posting{Term: term, Entry: entry, Blob: blob})
+		}
+
+		if len(postings) > 0 {
+			if _, err := o.InsertMulti(500, postings); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// SearchQuery is a search request; a Case restricts it to one case. Text is
+// matched as a case insensitive substring, or with Words as whole words found
+// through the index.
+type SearchQuery struct {
+	Text   string
+	Regex  *regexp.Regexp
+	Words  bool
+	Case   string
+	Limit  int
+	lower  string
+	terms  []string
+	blobs  map[int][]*File
+	cases  map[int]string
+	result *SearchResponse
+}
+
+func (q *SearchQuery) Match(line string) bool {
+	switch {
+	case q.Regex != nil:
+		return q.Regex.MatchString(line)
+	case q.Words:
+		found := make(map[string]bool)
+		for _, term := range Terms(line) {
+			found[term] = true
+		}
+
+		for _, term := range q.terms {
+			if !found[term] {
+				return false
+			}
+		}
+		return true
+	}
+	return strings.Contains(strings.ToLower(line), q.lower)
+}
+
+func intersect(a map[int]bool, b map[int]bool) map[int]bool {
+	result := make(map[int]bool)
+	for id := range a {
+		if b[id] {
+			result[id] = true
+		}
+	}
+	return result
+}
+
+// indexed returns the ids of the entries containing every word of the query,
+// in ascending order.
+func (q *SearchQuery) indexed(o orm.Ormer, blobIds []interface{}) ([]interface{}, error) {
+	var matching map[int]bool
+
+	for _, term := range q.terms {
+		found := make(map[int]bool)
+
+		for _, chunk := range ChunkIds(blobIds) {
+			var ids orm.ParamsList
+			_, err := o.QueryTable("posting").Filter("Term", term).Filter("Blob__in", chunk...).
+				Limit(-1).ValuesFlat(&ids, "Entry")
+			if err != nil {
+				return nil, err
+			}
+
+			for _, id := range ids {
+				entryId, _ := strconv.Atoi(fmt.Sprint(id))
+				found[entryId] = true
+			}
+		}
+
+		if matching == nil {
+			matching = found
+		} else {
+			matching = intersect(matching, found)
+		}
+
+		if len(matching) == 0 {
+			return nil, nil
+		}
+	}
+
+	sorted := make([]int, 0, len(matching))
+	for id := range matching {
+		sorted = append(sorted, id)
+	}
+	sort.Ints(sorted)
+
+	entryIds := make([]interface{}, len(sorted))
+	for i, id := range sorted {
+		entryIds[i] = id
+	}
+
+	return entryIds, nil
+}
+
+// candidates returns the entries that may match, by blob. Whole word searches
+// use the inverted index and only find entries containing all of their words,
+// other queries scan every entry up to MaxSearchScan.
+func (q *SearchQuery) candidates(o orm.Ormer, blobIds []interface{}) (map[int][]*Entry, error) {
+	field, ids := "Blob__in", blobIds
+
+	if q.Words {
+		entryIds, err := q.indexed(o, blobIds)
+		if err != nil || len(entryIds) == 0 {
+			return nil, err
+		}
+		field, ids = "Id__in", entryIds
+	}
+
+	var entries []*Entry
+	for _, chunk := range ChunkIds(ids) {
+		var found []*Entry
+		_, err := o.QueryTable("entry").Filter(field, chunk...).Filter("Type", "file").
+			OrderBy("Id").Limit(MaxSearchScan + 1 - len(entries)).All(&found)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, found...)
+		if len(entries) > MaxSearchScan {
+			break
+		}
+	}
+
+	if len(entries) > MaxSearchScan {
+		entries = entries[:MaxSearchScan]
+		q.result.Truncated = true
+	}
+
+	byBlob := make(map[int][]*Entry)
+	for _, entry := range entries {
+		byBlob[entry.Blob.Id] = append(byBlob[entry.Blob.Id], entry)
+	}
+
+	return byBlob, nil
+}
+
+// scan reads the candidate entries of one blob and records their matching
+// lines for every file stored in that blob.
+func (q *SearchQuery) scan(storage Storage, blob *Blob, entries []*Entry) error {
+	wanted := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		wanted[entry.Path] = true
+	}
+
+	content, err := storage.Get(BlobKey(blob.Digest))
+	if err != nil {
+		return err
+	}
+
+	defer content.Close()
+
+	archive, err := openArchive(content)
+	if err != nil || archive == nil {
+		return err
+	}
+
+	for len(wanted) > 0 {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		name := EntryPath(header.Name)
+		if !wanted[name] || header.Typeflag == tar.TypeDir || header.Size > MaxIndexedEntrySize {
+			continue
+		}
+		delete(wanted, name)
+
+		if done := q.scanEntry(blob, name, archive); done {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (q *SearchQuery) scanEntry(blob *Blob, name string, content io.Reader) bool {
+	reader := bufio.NewReaderSize(content, 64*1024)
+
+	if head, _ := reader.Peek(8192); !IsText(head) {
+		return false
+	}
+
+	for line := 1; ; line++ {
+		text, err := reader.ReadString('\n')
+		if len(text) > 0 && q.Match(text) {
+			text = strings.TrimRight(text, "\r\n")
+			if len(text) > 512 {
+				text = text[:512]
+			}
+
+			for _, file := range q.blobs[blob.Id] {
+				if len(q.result.Matches) == q.Limit {
+					q.result.Truncated = true
+					return true
+				}
+
+				q.result.Matches = append(q.result.Matches, &SearchMatch{
+					Case:   q.cases[file.Case.Id],
+					FileId: file.Id,
+					File:   file.Path,
+					Entry:  name,
+					Line:   line,
+					Text:   text,
+				})
+			}
+		}
+
+		if err != nil {
+			return false
+		}
+	}
+}
+
+// Search looks for q in the report entries of the cases whose reports user
+// may download. Matches quote report contents, so seeing a case is not enough.
+func Search(o orm.Ormer, storage Storage, user *User, q *SearchQuery) (*SearchResponse, error) {
+	q.result = &SearchResponse{Matches: []*SearchMatch{}}
+	q.lower = strings.ToLower(q.Text)
+	q.terms = Terms(q.Text)
+
+	cond, err := visibleCondition(o, user)
+	if err != nil || cond == nil {
+		return q.result, err
+	}
+
+	if q.Case != "" {
+		cond = cond.And("Uid", q.Case)
+	}
+
+	var cases []*Case
+	_, err = o.QueryTable("case").SetCond(cond).Limit(-1).All(&cases, "Id", "Uid", "Owner", "Team", "IsPrivate")
+	if err != nil {
+		return nil, err
+	}
+
+	q.cases = make(map[int]string, len(cases))
+	var caseIds []interface{}
+	for _, c := range cases {
+		if !CanAccess(o, user, "", c, ActionDownload) {
+			continue
+		}
+
+		q.cases[c.Id] = c.Uid
+		caseIds = append(caseIds, c.Id)
+	}
+
+	if len(caseIds) == 0 {
+		return q.result, nil
+	}
+
+	var files []*File
+	for _, chunk := range ChunkIds(caseIds) {
+		var found []*File
+		_, err = o.QueryTable("file").Filter("Case__in", chunk...).Filter("Blob__isnull", false).
+			OrderBy("Id").Limit(-1).All(&found, "Id", "Path", "Case", "Blob")
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, found...)
+	}
+
+	q.blobs = make(map[int][]*File)
+	var blobIds []interface{}
+	for _, file := range files {
+		if q.blobs[file.Blob.Id] == nil {
+			blobIds = append(blobIds, file.Blob.Id)
+		}
+		q.blobs[file.Blob.Id] = append(q.blobs[file.Blob.Id], file)
+	}
+
+	if len(blobIds) == 0 {
+		return q.result, nil
+	}
+
+	for _, chunk := range ChunkIds(blobIds) {
+		pending, err := o.QueryTable("blob").Filter("Id__in", chunk...).Filter("Indexed", false).Count()
+		if err != nil {
+			return nil, err
+		}
+		q.result.Indexing += int(pending)
+	}
+
+	byBlob, err := q.candidates(o, blobIds)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range blobIds {
+		entries := byBlob[id.(int)]
+		if len(entries) == 0 {
+			continue
+		}
+
+		blob := &Blob{Id: id.(int)}
+		if err := o.Read(blob); err != nil {
+			return nil, err
+		}
+
+		if err := q.scan(storage, blob, entries); err != nil {
+			return nil, err
+		}
+
+		if len(q.result.Matches) == q.Limit {
+			break
+		}
+	}
+
+	return q.result, nil
+}
+
+type SearchHandler struct {
+	Storage Storage
+}
+
+func (handler *SearchHandler) Search(request *restful.Request, response *restful.Response) {
+	q := &SearchQuery{
+		Text:  request.QueryParameter("q"),
+		Case:  request.QueryParameter("case"),
+		Limit: DefaultSearchLimit,
+	}
+
+	if q.Text == "" {
+		response.WriteErrorString(http.StatusBadRequest, "missing search query q")
+		return
+	}
+
+	q.Words = request.QueryParameter("words") == "true"
+	if q.Words && len(Terms(q.Text)) == 0 {
+		response.WriteErrorString(http.StatusBadRequest, fmt.Sprintf("no word of at least %d characters to search for", MinTermLength))
+		return
+	}
+
+	if request.QueryParameter("regex") == "true" {
+		if q.Words {
+			response.WriteErrorString(http.StatusBadRequest, "regex and words cannot be combined")
+			return
+		}
+
+		regex, err := regexp.Compile(q.Text)
+		if err != nil {
+			response.WriteErrorString(http.StatusBadRequest, fmt.Sprintf("invalid regular expression: %s", err))
+			return
+		}
+		q.Regex = regex
+	}
+
+	if limit := request.QueryParameter("limit"); limit != "" {
+		var err error
+		q.Limit, err = strconv.Atoi(limit)
+		if err != nil || q.Limit < 1 || q.Limit > MaxSearchLimit {
+			response.WriteErrorString(http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", MaxSearchLimit))
+			return
+		}
+	}
+
+	o := orm.NewOrm()
+	if err := Audit(o, request, AuditReportSearch, nil, 0, q.Text); err != nil {
+		response.WriteErrorString(http.StatusInternalServerError, "cannot record audit event")
+		return
+	}
+
+	result, err := Search(o, handler.Storage, CurrentUser(request), q)
+	if err != nil {
+		response.WriteErrorString(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.WriteEntity(result)
+}
+
+func SearchWebService(storage Storage) *restful.WebService {
+	handler := &SearchHandler{Storage: storage}
+
+	ws := new(restful.WebService)
+	ws.Path("/1/search").
+		Doc("Search the contents of uploaded reports").
+		Produces(restful.MIME_JSON)
+
+	ws.Route(ws.GET("").Filter(Authorize(ActionList)).To(handler.Search).
+		Doc("search the report entries of the cases the caller may download for a string or a regular expression").
+		Operation("search").
+		Param(ws.QueryParameter("q", "text to look for, case insensitive, or a regular expression")).
+		Param(ws.QueryParameter("words", "true to match whole words only, using the index")).
+		Param(ws.QueryParameter("regex", "true to read q as a regular expression")).
+		Param(ws.QueryParameter("case", "only search this case")).
+		Param(ws.QueryParameter("limit", "maximum number of matching lines").DataType("int")).
+		Writes(SearchResponse{}))
+
+	return ws
+}
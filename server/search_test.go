@@ -0,0 +1,135 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestSearchQueryMatch(t *testing.T) {
+	tests := []struct {
+		query *SearchQuery
+		line  string
+		match bool
+	}{
+		{&SearchQuery{Text: "mem"}, "Out of memory: killed process 42", true},
+		{&SearchQuery{Text: "MEMORY"}, "out of memory", true},
+		{&SearchQuery{Text: "of mem"}, "out of memory", true},
+		{&SearchQuery{Text: "swap"}, "out of memory", false},
+		{&SearchQuery{Text: "mem", Words: true}, "out of memory", false},
+		{&SearchQuery{Text: "memory out", Words: true}, "Out of memory", true},
+		{&SearchQuery{Text: "memory swap", Words: true}, "out of memory", false},
+		{&SearchQuery{Text: "kill", Regex: regexp.MustCompile(`kill(ed)? process \d+`)}, "killed process 42", true},
+	}
+
+	for _, test := range tests {
+		test.query.lower = strings.ToLower(test.query.Text)
+		test.query.terms = Terms(test.query.Text)
+
+		if got := test.query.Match(test.line); got != test.match {
+			t.Errorf("%q (words %t) on %q: got %t, want %t", test.query.Text, test.query.Words, test.line, got, test.match)
+		}
+	}
+}
+
+func TestChunkIds(t *testing.T) {
+	for _, count := range []int{0, 1, maxQueryIds, maxQueryIds + 1, 3*maxQueryIds + 7} {
+		ids := make([]interface{}, count)
+		for i := range ids {
+			ids[i] = i
+		}
+
+		total := 0
+		for _, chunk := range ChunkIds(ids) {
+			if len(chunk) == 0 || len(chunk) > maxQueryIds {
+				t.Errorf("%d ids: chunk of %d", count, len(chunk))
+			}
+			for _, id := range chunk {
+				if id != total {
+					t.Fatalf("%d ids: got %v at %d", count, id, total)
+				}
+				total++
+			}
+		}
+
+		if total != count {
+			t.Errorf("%d ids: chunks hold %d", count, total)
+		}
+	}
+}
+
+func testArchive(t *testing.T, files map[string]string) []byte {
+	var buffer bytes.Buffer
+
+	archive := tar.NewWriter(&buffer)
+	for name, content := range files {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := archive.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := archive.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestSearchNeedsDownloadAccess(t *testing.T) {
+	o := openTestDatabase(t)
+
+	root, err := ioutil.TempDir("", "mayday-search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	storage := &LocalStorage{Root: root}
+	data := testArchive(t, map[string]string{"var/log/messages": "kernel: Out of memory: killed process 42\n"})
+
+	blob, err := StoreBlob(o, storage, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DropBlob(o, storage, blob)
+
+	if err := IndexBlob(o, blob, data); err != nil {
+		t.Fatal(err)
+	}
+
+	rows := &testRows{t: t, o: o}
+	defer rows.remove()
+
+	engineer := rows.user("search-engineer", RoleEngineer, "")
+	customer := rows.user("search-customer", RoleCustomer, "")
+
+	c := rows.newCase(engineer, "")
+	rows.invite(c, customer)
+	rows.insert(&File{Path: "report.tar", Case: c, Blob: blob})
+
+	tests := []struct {
+		user    *User
+		matches int
+	}{
+		{engineer, 1},
+		{customer, 0},
+	}
+
+	for _, test := range tests {
+		result, err := Search(o, storage, test.user, &SearchQuery{Text: "out of memory", Limit: DefaultSearchLimit})
+		if err != nil {
+			t.Fatalf("%s: %s", test.user.Name, err)
+		}
+
+		if len(result.Matches) != test.matches {
+			t.Errorf("%s: got %d matches, want %d: %+v", test.user.Name, len(result.Matches), test.matches, result.Matches)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"fmt"
+	"github.com/astaxie/beego/orm"
+	"github.com/emicklei/go-restful"
+	"net/http"
+	"time"
+)
+
+const (
+	StatusOpen           = "open"
+	StatusAwaitingReport = "awaiting-report"
+	StatusReportReceived = "report-received"
+	StatusInvestigating  = "investigating"
+	StatusClosed         = "closed"
+	StatusArchived       = "archived"
+)
+
+// Transitions lists the states a case may move to from each state. Archived
+// cases are final.
+var Transitions = map[string][]string{
+	StatusOpen:           {StatusAwaitingReport, StatusReportReceived, StatusInvestigating, StatusClosed},
+	StatusAwaitingReport: {StatusReportReceived, StatusInvestigating, StatusClosed},
+	StatusReportReceived: {StatusAwaitingReport, StatusInvestigating, StatusClosed},
+	StatusInvestigating:  {StatusAwaitingReport, StatusReportReceived, StatusClosed},
+	StatusClosed:         {StatusOpen, StatusArchived},
+	StatusArchived:       {},
+}
+
+// CaseTransition records a status change of a case and the name of the
+// user who made it, empty for automatic changes.
+type CaseTransition struct {
+	Id         int       `orm:"auto"`
+	Case       *Case     `orm:"rel(fk)" json:"-"`
+	FromStatus string    `orm:"size(32)"`
+	ToStatus   string    `orm:"size(32)"`
+	Actor      string    `orm:"default();size(128)"`
+	Reason     string    `orm:"default()"`
+	Created    time.Time `orm:"auto_now_add;type(datetime)"`
+}
+
+type StatusRequest struct {
+	Status string
+	Reason string
+}
+
+func ValidStatus(status string) bool {
+	_, ok := Transitions[status]
+	return ok
+}
+
+func CanTransition(from string, to string) bool {
+	for _, status := range Transitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// AcceptsUploads tells whether reports can still be added to a case.
+func AcceptsUploads(c *Case) bool {
+	return c.Status != StatusClosed && c.Status != StatusArchived
+}
+
+// Transition moves c to status and records it in the case history. It fails
+// when the move is not allowed or when the case changed status meanwhile.
+func Transition(o orm.Ormer, c *Case, status string, user *User, reason string) error {
+	if !ValidStatus(status) {
+		return fmt.Errorf("unknown case status: %s", status)
+	}
+
+	if !CanTransition(c.Status, status) {
+		return fmt.Errorf("case cannot move from %s to %s", c.Status, status)
+	}
+
+	now := time.Now()
+	transition := &CaseTransition{Case: c, FromStatus: c.Status, ToStatus: status, Reason: reason}
+	if user != nil {
+		transition.Actor = user.Name
+	}
+
+	// The history row is committed with the move or not at all.
+	err := InTransaction(o, func() error {
+		updated, err := o.QueryTable("case").Filter("Id", c.Id).Filter("Status", c.Status).
+			Update(orm.Params{"Status": status, "Updated": now})
+		if err != nil {
+			return err
+		}
+
+		if updated == 0 {
+			return fmt.Errorf("case status changed concurrently, retry")
+		}
+
+		_, err = o.Insert(transition)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	c.Status = status
+	c.Updated = now
+
+	Notify(o, EventStatusChanged, c, transition)
+	if status == StatusClosed {
+		Notify(o, EventCaseClosed, c, transition)
+	}
+
+	return nil
+}
+
+func (handler *CaseHandler) SetStatus(request *restful.Request, response *restful.Response) {
+	c := CurrentCase(request)
+
+	s := new(StatusRequest)
+	if err := request.ReadEntity(s); err != nil {
+		response.WriteErrorString(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := Transition(orm.NewOrm(), c, s.Status, CurrentUser(request), s.Reason); err != nil {
+		response.WriteErrorString(http.StatusConflict, err.Error())
+		return
+	}
+
+	response.WriteEntity(c)
+}
+
+func (handler *CaseHandler) History(request *restful.Request, response *restful.Response) {
+	var transitions []*CaseTransition
+
+	_, err := orm.NewOrm().QueryTable("case_transition").Filter("Case", CurrentCase(request).Id).
+		OrderBy("Id").All(&transitions)
+	if err != nil {
+		response.WriteErrorString(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.WriteEntity(transitions)
+}
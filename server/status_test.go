@@ -0,0 +1,43 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestTransitionRecordsHistory(t *testing.T) {
+	o := openTestDatabase(t)
+
+	rows := &testRows{t: t, o: o}
+	defer rows.remove()
+
+	engineer := rows.user("status-engineer", RoleEngineer, "")
+	c := rows.newCase(engineer, "")
+	defer o.QueryTable("case_transition").Filter("Case", c.Id).Delete()
+
+	stale := *c
+
+	if err := Transition(o, c, StatusInvestigating, engineer, "looking"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Transition(o, &stale, StatusClosed, engineer, "done"); err == nil {
+		t.Error("expected a concurrent change to be refused")
+	}
+
+	var history []*CaseTransition
+	if _, err := o.QueryTable("case_transition").Filter("Case", c.Id).All(&history); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(history) != 1 || history[0].ToStatus != StatusInvestigating || history[0].Actor != engineer.Name {
+		t.Errorf("unexpected history: %+v", history)
+	}
+
+	stored, err := LoadCase(o, c.Uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != StatusInvestigating {
+		t.Errorf("case status %s, want %s", stored.Status, StatusInvestigating)
+	}
+}
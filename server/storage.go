@@ -0,0 +1,302 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	StorageLocal = "local"
+	StorageS3    = "s3"
+
+	DefaultS3Region = "us-east-1"
+)
+
+var ErrStorageNotFound = fmt.Errorf("object not found in storage")
+
+// Storage keeps the contents of case files. Keys are slash separated
+// relative paths chosen by the server, never by clients.
+type Storage interface {
+	Put(key string, data io.Reader, size int64) error
+	Get(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+type StorageConfig struct {
+	Backend string   `yaml:"backend"`
+	Path    string   `yaml:"path"`
+	S3      S3Config `yaml:"s3"`
+}
+
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint"`
+	Bucket    string `yaml:"bucket"`
+	Region    string `yaml:"region"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+}
+
+type LocalStorage struct {
+	Root string
+}
+
+// S3Storage talks to an S3-compatible object store (AWS S3, MinIO, Ceph)
+// using path-style requests signed with AWS Signature Version 4.
+type S3Storage struct {
+	Config S3Config
+	Client *http.Client
+}
+
+func NewStorage(config *StorageConfig) (Storage, error) {
+	switch config.Backend {
+	case "", StorageLocal:
+		return &LocalStorage{Root: config.Path}, nil
+	case StorageS3:
+		if config.S3.Endpoint == "" || config.S3.Bucket == "" {
+			return nil, fmt.Errorf("s3 storage requires an endpoint and a bucket")
+		}
+
+		if config.S3.Region == "" {
+			config.S3.Region = DefaultS3Region
+		}
+
+		return &S3Storage{Config: config.S3, Client: &http.Client{Timeout: 5 * time.Minute}}, nil
+	}
+
+	return nil, fmt.Errorf("unknown storage backend: %s", config.Backend)
+}
+
+func (s *LocalStorage) Path(key string) string {
+	return filepath.Join(s.Root, filepath.FromSlash(path.Clean("/"+key)))
+}
+
+func (s *LocalStorage) Put(key string, data io.Reader, size int64) error {
+	fullpath := s.Path(key)
+
+	if err := os.MkdirAll(filepath.Dir(fullpath), 0700); err != nil {
+		return err
+	}
+
+	// Write aside and rename, readers never see a partial object.
+	output, err := ioutil.TempFile(filepath.Dir(fullpath), "."+filepath.Base(fullpath)+".")
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(output, data)
+	if err == nil {
+		err = output.Sync()
+	}
+
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(output.Name(), fullpath)
+	}
+
+	if err != nil {
+		os.Remove(output.Name())
+	}
+
+	return err
+}
+
+func (s *LocalStorage) Get(key string) (io.ReadCloser, error) {
+	input, err := os.Open(s.Path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrStorageNotFound
+	}
+
+	return input, err
+}
+
+func (s *LocalStorage) Delete(key string) error {
+	err := os.Remove(s.Path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+func (s *S3Storage) ObjectPath(key string) string {
+	return "/" + s3Escape(s.Config.Bucket) + "/" + s3Escape(strings.TrimLeft(key, "/"))
+}
+
+func (s *S3Storage) do(method string, key string, body io.Reader, size int64) (*http.Response, error) {
+	endpoint, err := url.Parse(strings.TrimRight(s.Config.Endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint: %s", err)
+	}
+
+	objectPath := s.ObjectPath(key)
+	request, err := http.NewRequest(method, endpoint.Scheme+"://"+endpoint.Host+objectPath, body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the escaping used for the signature on the wire.
+	request.URL.Opaque = "//" + endpoint.Host + objectPath
+
+	if body != nil {
+		request.ContentLength = size
+	}
+
+	s.Sign(request, objectPath, time.Now().UTC())
+	return s.Client.Do(request)
+}
+
+// Sign adds an AWS Signature Version 4 Authorization header to request.
+// Payloads are not hashed, which S3 and MinIO accept as UNSIGNED-PAYLOAD.
+func (s *S3Storage) Sign(request *http.Request, canonicalURI string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := "UNSIGNED-PAYLOAD"
+
+	request.Header.Set("x-amz-date", amzDate)
+	request.Header.Set("x-amz-content-sha256", payloadHash)
+
+	headers := map[string]string{
+		"host":                 request.URL.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           amzDate,
+	}
+
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders string
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		canonicalURI,
+		"",
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{day, s.Config.Region, "s3", "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexSHA256([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.Config.SecretKey), day)
+	key = hmacSHA256(key, s.Config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.Config.AccessKey, scope, signedHeaders, signature))
+}
+
+func (s *S3Storage) Put(key string, data io.Reader, size int64) error {
+	response, err := s.do("PUT", key, data, size)
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return s3Error(response)
+	}
+
+	return nil
+}
+
+func (s *S3Storage) Get(key string) (io.ReadCloser, error) {
+	response, err := s.do("GET", key, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode == http.StatusNotFound {
+		response.Body.Close()
+		return nil, ErrStorageNotFound
+	}
+
+	if response.StatusCode != http.StatusOK {
+		defer response.Body.Close()
+		return nil, s3Error(response)
+	}
+
+	return response.Body, nil
+}
+
+func (s *S3Storage) Delete(key string) error {
+	response, err := s.do("DELETE", key, nil, 0)
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusNoContent && response.StatusCode != http.StatusOK &&
+		response.StatusCode != http.StatusNotFound {
+		return s3Error(response)
+	}
+
+	return nil
+}
+
+func s3Error(response *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 4096))
+	return fmt.Errorf("s3 request failed: %s: %s", response.Status, strings.TrimSpace(string(body)))
+}
+
+// s3Escape URI-encodes every path segment the way Signature Version 4
+// expects: only unreserved characters are left as they are.
+func s3Escape(key string) string {
+	var escaped []byte
+
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/':
+			escaped = append(escaped, c)
+		default:
+			escaped = append(escaped, []byte(fmt.Sprintf("%%%02X", c))...)
+		}
+	}
+
+	return string(escaped)
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+type failingReader struct {
+	data io.Reader
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	n, err := r.data.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+func testStorage(t *testing.T, storage Storage) {
+	keys := []string{"blobs/ab/abcdef", "1/report with spaces.tar.gz", "1/unicode-é+%.txt"}
+
+	for _, key := range keys {
+		data := []byte("content of " + key)
+		if err := storage.Put(key, bytes.NewReader(data), int64(len(data))); err != nil {
+			t.Fatalf("%s: put: %s", key, err)
+		}
+
+		content, err := storage.Get(key)
+		if err != nil {
+			t.Fatalf("%s: get: %s", key, err)
+		}
+
+		got, err := ioutil.ReadAll(content)
+		content.Close()
+		if err != nil {
+			t.Fatalf("%s: read: %s", key, err)
+		}
+
+		if !bytes.Equal(got, data) {
+			t.Errorf("%s: got %q, want %q", key, got, data)
+		}
+
+		if err := storage.Delete(key); err != nil {
+			t.Errorf("%s: delete: %s", key, err)
+		}
+
+		if _, err := storage.Get(key); err != ErrStorageNotFound {
+			t.Errorf("%s: expected not found after delete, got %v", key, err)
+		}
+	}
+}
+
+func TestLocalStorage(t *testing.T) {
+	root, err := ioutil.TempDir("", "mayday-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	testStorage(t, &LocalStorage{Root: root})
+}
+
+func TestLocalStoragePutFailureKeepsObject(t *testing.T) {
+	root, err := ioutil.TempDir("", "mayday-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	storage := &LocalStorage{Root: root}
+	if err := storage.Put("1/file", bytes.NewReader([]byte("original")), 8); err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.Put("1/file", &failingReader{bytes.NewReader([]byte("partial"))}, 7)
+	if err == nil {
+		t.Fatal("expected the interrupted put to fail")
+	}
+
+	content, err := storage.Get("1/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer content.Close()
+
+	if got, _ := ioutil.ReadAll(content); string(got) != "original" {
+		t.Errorf("got %q after a failed put, want the original content", got)
+	}
+
+	entries, err := ioutil.ReadDir(storage.Path("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 {
+		t.Errorf("expected the temporary file to be removed, found %d entries", len(entries))
+	}
+}
+
+// TestS3Storage runs against a real S3-compatible store, e.g. MinIO:
+//
+//	docker run -p 9000:9000 -e MINIO_ROOT_USER=mayday -e MINIO_ROOT_PASSWORD=maydaytest minio/minio server /data
+//	MAYDAY_S3_ENDPOINT=http://localhost:9000 MAYDAY_S3_BUCKET=mayday \
+//	MAYDAY_S3_ACCESS_KEY=mayday MAYDAY_S3_SECRET_KEY=maydaytest go test -run S3 mayday/server
+//
+// The bucket must exist.
+func TestS3Storage(t *testing.T) {
+	endpoint := os.Getenv("MAYDAY_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("MAYDAY_S3_ENDPOINT is not set")
+	}
+
+	storage, err := NewStorage(&StorageConfig{
+		Backend: StorageS3,
+		S3: S3Config{
+			Endpoint:  endpoint,
+			Bucket:    os.Getenv("MAYDAY_S3_BUCKET"),
+			Region:    os.Getenv("MAYDAY_S3_REGION"),
+			AccessKey: os.Getenv("MAYDAY_S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("MAYDAY_S3_SECRET_KEY"),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testStorage(t, storage)
+}
@@ -0,0 +1,268 @@
+package server
+
+import (
+	"fmt"
+	"github.com/astaxie/beego/orm"
+	"github.com/emicklei/go-restful"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ScopeRead     = "read"
+	ScopeUpload   = "upload"
+	ScopeDownload = "download"
+
+	DefaultTokenValidity = 7 * 24 * time.Hour
+
+	// NoExpiry creates a token that never expires, like the initial token of
+	// a private case and the migrated tokens of older cases, so links already
+	// handed to customers keep working. Revoke them to end access.
+	NoExpiry time.Duration = -1
+)
+
+// NeverExpires is the expiry time of tokens created with NoExpiry.
+var NeverExpires = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// Token is a case credential limited to a set of scopes, an expiry time and
+// optionally a number of uses. Only the hash of the secret is stored.
+type Token struct {
+	Id          int       `orm:"auto"`
+	Case        *Case     `orm:"rel(fk)" json:"-"`
+	Secret      string    `orm:"unique;size(64)" json:"-"`
+	Scopes      string    `orm:"size(64)"`
+	Description string    `orm:"default()"`
+	Expires     time.Time `orm:"type(datetime)"`
+	MaxUses     int       `orm:"default(0)"`
+	Uses        int       `orm:"default(0)"`
+	Revoked     bool      `orm:"default(false)"`
+	Created     time.Time `orm:"auto_now_add;type(datetime)"`
+}
+
+type TokenRequest struct {
+	Scopes      []string
+	ExpiresIn   string
+	MaxUses     int
+	Description string
+}
+
+type TokenResponse struct {
+	Token  *Token
+	Secret string
+}
+
+type TokenHandler struct{}
+
+func ValidScope(scope string) bool {
+	return scope == ScopeRead || scope == ScopeUpload || scope == ScopeDownload
+}
+
+// ScopeAllows tells whether scope grants action. Reading the case
+// configuration is needed both to run a case and to review it, so it is
+// allowed by the upload and read scopes alike.
+func ScopeAllows(scope string, action string) bool {
+	switch action {
+	case ActionRead:
+		return scope == ScopeRead || scope == ScopeUpload
+	case ActionUpload:
+		return scope == ScopeUpload
+	case ActionDownload:
+		return scope == ScopeDownload
+	}
+
+	return false
+}
+
+func (t *Token) HasScope(action string) bool {
+	for _, scope := range strings.Split(t.Scopes, ",") {
+		if ScopeAllows(scope, action) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t *Token) Valid(now time.Time) bool {
+	return !t.Revoked && now.Before(t.Expires) && (t.MaxUses == 0 || t.Uses < t.MaxUses)
+}
+
+func NewToken(o orm.Ormer, c *Case, scopes []string, validity time.Duration, maxUses int, description string) (*TokenResponse, error) {
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("a token needs at least one scope")
+	}
+
+	for _, scope := range scopes {
+		if !ValidScope(scope) {
+			return nil, fmt.Errorf("invalid token scope: %s", scope)
+		}
+	}
+
+	expires := NeverExpires
+	if validity != NoExpiry {
+		if validity <= 0 {
+			validity = DefaultTokenValidity
+		}
+		expires = time.Now().Add(validity)
+	}
+
+	secret, err := NewSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	token := &Token{
+		Case:        c,
+		Secret:      HashSecret(secret),
+		Scopes:      strings.Join(scopes, ","),
+		Description: description,
+		Expires:     expires,
+		MaxUses:     maxUses,
+	}
+
+	if _, err := o.Insert(token); err != nil {
+		return nil, err
+	}
+
+	return &TokenResponse{Token: token, Secret: secret}, nil
+}
+
+// AuthorizeToken checks secret against the tokens of the case. Uploads and
+// downloads count towards the usage limit, configuration reads do not.
+func AuthorizeToken(o orm.Ormer, c *Case, secret string, action string) bool {
+	if secret == "" {
+		return false
+	}
+
+	token := Token{Secret: HashSecret(secret)}
+	if err := o.Read(&token, "Secret"); err != nil {
+		return false
+	}
+
+	if token.Case == nil || token.Case.Id != c.Id || !token.Valid(time.Now()) || !token.HasScope(action) {
+		return false
+	}
+
+	if action == ActionRead {
+		return true
+	}
+
+	updated, err := o.QueryTable("token").Filter("Id", token.Id).Filter("Uses", token.Uses).
+		Update(orm.Params{"Uses": token.Uses + 1})
+
+	return err == nil && updated == 1
+}
+
+func (handler *TokenHandler) Create(request *restful.Request, response *restful.Response) {
+	t := new(TokenRequest)
+	if err := request.ReadEntity(t); err != nil {
+		response.WriteErrorString(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var validity time.Duration
+	if t.ExpiresIn != "" {
+		var err error
+		validity, err = time.ParseDuration(t.ExpiresIn)
+		if err != nil || validity <= 0 {
+			response.WriteErrorString(http.StatusBadRequest, "invalid expiry duration")
+			return
+		}
+	}
+
+	c := CurrentCase(request)
+	o := orm.NewOrm()
+
+	var created *TokenResponse
+	var auditErr error
+
+	err := InTransaction(o, func() error {
+		var err error
+		if created, err = NewToken(o, c, t.Scopes, validity, t.MaxUses, t.Description); err != nil {
+			return err
+		}
+
+		auditErr = AppendAudit(o, NewAuditEvent(request, AuditTokenCreate, c, 0, fmt.Sprintf("token %d", created.Token.Id)))
+		return auditErr
+	})
+	if auditErr != nil {
+		response.WriteErrorString(http.StatusInternalServerError, auditErr.Error())
+		return
+	} else if err != nil {
+		response.WriteErrorString(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	response.WriteHeader(http.StatusCreated)
+	response.WriteEntity(created)
+}
+
+func (handler *TokenHandler) List(request *restful.Request, response *restful.Response) {
+	var tokens []*Token
+
+	_, err := orm.NewOrm().QueryTable("token").Filter("Case", CurrentCase(request).Id).OrderBy("Id").All(&tokens)
+	if err != nil {
+		response.WriteErrorString(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.WriteEntity(tokens)
+}
+
+func (handler *TokenHandler) Revoke(request *restful.Request, response *restful.Response) {
+	id, err := strconv.Atoi(request.PathParameter("token-id"))
+	if err != nil {
+		response.WriteErrorString(http.StatusBadRequest, "invalid provided token id")
+		return
+	}
+
+	c := CurrentCase(request)
+	o := orm.NewOrm()
+
+	err = InTransaction(o, func() error {
+		revoked, err := o.QueryTable("token").Filter("Id", id).Filter("Case", c.Id).Update(orm.Params{"Revoked": true})
+		if err != nil {
+			return err
+		}
+
+		if revoked == 0 {
+			return orm.ErrNoRows
+		}
+
+		return AppendAudit(o, NewAuditEvent(request, AuditTokenRevoke, c, 0, fmt.Sprintf("token %d", id)))
+	})
+	if err == orm.ErrNoRows {
+		response.WriteErrorString(http.StatusNotFound, "not found specified token")
+		return
+	} else if err != nil {
+		response.WriteErrorString(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.WriteHeader(http.StatusNoContent)
+}
+
+func AddTokenRoutes(ws *restful.WebService) {
+	handler := &TokenHandler{}
+
+	ws.Route(ws.POST("/{case-id}/token").Filter(Authorize(ActionManage)).To(handler.Create).
+		Doc("create a scoped token for a case").
+		Operation("createToken").
+		Param(ws.PathParameter("case-id", "case identifier").DataType("string")).
+		Reads(TokenRequest{}).
+		Writes(TokenResponse{}))
+
+	ws.Route(ws.GET("/{case-id}/token").Filter(Authorize(ActionManage)).To(handler.List).
+		Doc("list the tokens of a case").
+		Operation("listTokens").
+		Param(ws.PathParameter("case-id", "case identifier").DataType("string")).
+		Writes([]Token{}))
+
+	ws.Route(ws.DELETE("/{case-id}/token/{token-id}").Filter(Authorize(ActionManage)).To(handler.Revoke).
+		Doc("revoke a token").
+		Operation("revokeToken").
+		Param(ws.PathParameter("case-id", "case identifier").DataType("string")).
+		Param(ws.PathParameter("token-id", "token identifier").DataType("int")))
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/emicklei/go-restful"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUploadFilesRejectsUnsafeNames(t *testing.T) {
+	handler := &CaseHandler{}
+
+	names := []string{
+		"../../.ssh/authorized_keys",
+		"/etc/passwd",
+		`..\..\boot.ini`,
+		`C:\Windows\win.ini`,
+		"report\x00.txt",
+		"..",
+		strings.Repeat("a", 256),
+	}
+
+	for _, name := range names {
+		body, _ := json.Marshal(&UploadFile{Filename: name, Content: "cmVwb3J0"})
+
+		httpRequest, _ := http.NewRequest("POST", "/1/case/uid/file", strings.NewReader(string(body)))
+		httpRequest.Header.Set("Content-Type", restful.MIME_JSON)
+
+		request := restful.NewRequest(httpRequest)
+		request.SetAttribute(CaseAttribute, &Case{Uid: "uid", Status: StatusOpen})
+
+		recorder := httptest.NewRecorder()
+		handler.UploadFiles(request, restful.NewResponse(recorder))
+
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("%q: got status %d, want %d", name, recorder.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,523 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/astaxie/beego/orm"
+	"github.com/emicklei/go-restful"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	EventCaseCreated       = "case.created"
+	EventConfigUpdated     = "case.config_updated"
+	EventFileUploaded      = "file.uploaded"
+	EventStatusChanged     = "case.status_changed"
+	EventCaseClosed        = "case.closed"
+	EventPing              = "ping"
+	DeliveryPending        = "pending"
+	DeliverySending        = "sending"
+	DeliveryDelivered      = "delivered"
+	DeliveryFailed         = "failed"
+	WebhookSignatureHeader = "X-Mayday-Signature"
+
+	MaxDeliveryAttempts = 8
+	DeliveryBaseDelay   = 30 * time.Second
+	DeliveryMaxDelay    = time.Hour
+	DeliveryTimeout     = 10 * time.Second
+	DeliveryPoll        = 15 * time.Second
+
+	// DeliveryClaimTimeout releases the deliveries claimed by a dispatcher
+	// that died while sending them.
+	DeliveryClaimTimeout = 5 * time.Minute
+)
+
+var Events = []string{EventCaseCreated, EventConfigUpdated, EventFileUploaded, EventStatusChanged, EventCaseClosed}
+
+// webhookWake tells the dispatcher that new deliveries are pending.
+var webhookWake = make(chan bool, 1)
+
+// Webhook is an outbound HTTP notification of case events. Its secret signs
+// the payloads and is only returned when the webhook is created.
+type Webhook struct {
+	Id          int       `orm:"auto"`
+	Url         string    `orm:"size(1024)"`
+	Secret      string    `orm:"size(64)" json:"-"`
+	Events      string    `orm:"default();size(255)"`
+	Description string    `orm:"default()"`
+	Active      bool      `orm:"default(true)"`
+	Created     time.Time `orm:"auto_now_add;type(datetime)"`
+}
+
+// WebhookDelivery is one event sent, or to be sent, to a webhook.
+type WebhookDelivery struct {
+	Id           int       `orm:"auto"`
+	Webhook      *Webhook  `orm:"rel(fk)" json:"-"`
+	Event        string    `orm:"size(64)"`
+	Payload      string    `orm:"type(text)"`
+	Status       string    `orm:"size(16);index"`
+	Attempts     int       `orm:"default(0)"`
+	ResponseCode int       `orm:"default(0)"`
+	Error        string    `orm:"default();type(text)"`
+	NextAttempt  time.Time `orm:"type(datetime)"`
+	Created      time.Time `orm:"auto_now_add;type(datetime)"`
+	Updated      time.Time `orm:"auto_now;type(datetime)"`
+}
+
+type WebhookRequest struct {
+	Url         string
+	Events      []string
+	Description string
+}
+
+type WebhookResponse struct {
+	Webhook *Webhook
+	Secret  string
+}
+
+// WebhookPayload is the signed JSON document posted to webhooks.
+type WebhookPayload struct {
+	Event string
+	Time  time.Time
+	Case  *CaseSummary `json:",omitempty"`
+	Data  interface{}  `json:",omitempty"`
+}
+
+type WebhookHandler struct{}
+
+func ValidEvent(event string) bool {
+	for _, e := range Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *Webhook) Subscribed(event string) bool {
+	if w.Events == "" || event == EventPing {
+		return true
+	}
+
+	for _, e := range strings.Split(w.Events, ",") {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// SignPayload returns the signature header value of body: the hex encoded
+// HMAC-SHA256 of the body keyed with the webhook secret.
+func SignPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// DeliveryDelay is the exponential backoff before the next attempt of a
+// delivery that failed attempts times.
+func DeliveryDelay(attempts int) time.Duration {
+	delay := DeliveryBaseDelay
+	for i := 1; i < attempts && delay < DeliveryMaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > DeliveryMaxDelay {
+		delay = DeliveryMaxDelay
+	}
+	return delay
+}
+
+func enqueue(o orm.Ormer, webhook *Webhook, event string, payload []byte) (*WebhookDelivery, error) {
+	delivery := &WebhookDelivery{
+		Webhook:     webhook,
+		Event:       event,
+		Payload:     string(payload),
+		Status:      DeliveryPending,
+		NextAttempt: time.Now(),
+	}
+
+	if _, err := o.Insert(delivery); err != nil {
+		return nil, err
+	}
+
+	select {
+	case webhookWake <- true:
+	default:
+	}
+
+	return delivery, nil
+}
+
+// Notify queues event about c for every active webhook subscribed to it.
+// Failures are logged, they never fail the request that caused the event.
+func Notify(o orm.Ormer, event string, c *Case, data interface{}) {
+	var webhooks []*Webhook
+
+	if _, err := o.QueryTable("webhook").Filter("Active", true).All(&webhooks); err != nil {
+		log.Printf("cannot load webhooks for %s: %s", event, err)
+		return
+	}
+
+	var payload []byte
+	for _, webhook := range webhooks {
+		if !webhook.Subscribed(event) {
+			continue
+		}
+
+		if payload == nil {
+			if c.Owner != nil && c.Owner.Name == "" {
+				o.Read(c.Owner)
+			}
+
+			var err error
+			payload, err = json.Marshal(WebhookPayload{Event: event, Time: time.Now(), Case: NewCaseSummary(c), Data: data})
+			if err != nil {
+				log.Printf("cannot encode %s payload: %s", event, err)
+				return
+			}
+		}
+
+		if _, err := enqueue(o, webhook, event, payload); err != nil {
+			log.Printf("cannot queue %s for webhook %d: %s", event, webhook.Id, err)
+		}
+	}
+}
+
+// Deliver makes one attempt at sending delivery and records its outcome.
+func Deliver(o orm.Ormer, client *http.Client, delivery *WebhookDelivery) error {
+	webhook := delivery.Webhook
+	if err := o.Read(webhook); err != nil {
+		return err
+	}
+
+	delivery.Attempts++
+	delivery.ResponseCode = 0
+	delivery.Error = ""
+
+	var request *http.Request
+	var err error
+	if !webhook.Active {
+		err = fmt.Errorf("webhook was deleted")
+	} else {
+		request, err = http.NewRequest("POST", webhook.Url, strings.NewReader(delivery.Payload))
+	}
+
+	if err == nil {
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("User-Agent", "mayday-webhook")
+		request.Header.Set("X-Mayday-Event", delivery.Event)
+		request.Header.Set("X-Mayday-Delivery", strconv.Itoa(delivery.Id))
+		request.Header.Set(WebhookSignatureHeader, SignPayload(webhook.Secret, []byte(delivery.Payload)))
+
+		var response *http.Response
+		response, err = client.Do(request)
+		if err == nil {
+			io.Copy(ioutil.Discard, io.LimitReader(response.Body, 64*1024))
+			response.Body.Close()
+
+			delivery.ResponseCode = response.StatusCode
+			if response.StatusCode < 200 || response.StatusCode > 299 {
+				err = fmt.Errorf("receiver answered %s", response.Status)
+			}
+		}
+	}
+
+	switch {
+	case err == nil:
+		delivery.Status = DeliveryDelivered
+	case delivery.Attempts >= MaxDeliveryAttempts || !webhook.Active:
+		delivery.Status = DeliveryFailed
+		delivery.Error = err.Error()
+	default:
+		delivery.Status = DeliveryPending
+		delivery.Error = err.Error()
+		delivery.NextAttempt = time.Now().Add(DeliveryDelay(delivery.Attempts))
+	}
+
+	_, updateErr := o.Update(delivery, "Status", "Attempts", "ResponseCode", "Error", "NextAttempt", "Updated")
+	return updateErr
+}
+
+// ClaimDelivery marks delivery as being sent, so that no other dispatcher
+// sends it too. It tells whether this one got it.
+func ClaimDelivery(o orm.Ormer, delivery *WebhookDelivery) (bool, error) {
+	updated, err := o.QueryTable("webhook_delivery").Filter("Id", delivery.Id).Filter("Status", DeliveryPending).
+		Filter("Attempts", delivery.Attempts).Update(orm.Params{"Status": DeliverySending, "Updated": time.Now()})
+	if err != nil || updated == 0 {
+		return false, err
+	}
+
+	delivery.Status = DeliverySending
+	return true, nil
+}
+
+// DeliverPending claims and attempts every delivery that is due.
+func DeliverPending(o orm.Ormer, client *http.Client) error {
+	_, err := o.QueryTable("webhook_delivery").Filter("Status", DeliverySending).
+		Filter("Updated__lt", time.Now().Add(-DeliveryClaimTimeout)).Update(orm.Params{"Status": DeliveryPending})
+	if err != nil {
+		return err
+	}
+
+	var deliveries []*WebhookDelivery
+	_, err = o.QueryTable("webhook_delivery").Filter("Status", DeliveryPending).
+		Filter("NextAttempt__lte", time.Now()).OrderBy("Id").Limit(100).All(&deliveries)
+	if err != nil {
+		return err
+	}
+
+	for _, delivery := range deliveries {
+		claimed, err := ClaimDelivery(o, delivery)
+		if err != nil {
+			return err
+		} else if !claimed {
+			continue
+		}
+
+		if err := Deliver(o, client, delivery); err != nil {
+			log.Printf("cannot record webhook delivery %d: %s", delivery.Id, err)
+		}
+	}
+
+	return nil
+}
+
+// StartWebhooks runs the delivery loop in the background. Deliveries are
+// stored first, so pending ones survive restarts.
+func StartWebhooks() {
+	client := &http.Client{Timeout: DeliveryTimeout}
+
+	go func() {
+		for {
+			if err := DeliverPending(orm.NewOrm(), client); err != nil {
+				log.Printf("webhook dispatcher failed: %s", err)
+			}
+
+			select {
+			case <-webhookWake:
+			case <-time.After(DeliveryPoll):
+			}
+		}
+	}()
+}
+
+func NewWebhook(o orm.Ormer, r *WebhookRequest) (*WebhookResponse, error) {
+	target, err := url.Parse(r.Url)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, fmt.Errorf("a webhook needs an http or https url")
+	}
+
+	for _, event := range r.Events {
+		if !ValidEvent(event) {
+			return nil, fmt.Errorf("unknown event %s, expected one of: %s", event, strings.Join(Events, ", "))
+		}
+	}
+
+	secret, err := NewSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	webhook := &Webhook{
+		Url:         r.Url,
+		Secret:      secret,
+		Events:      strings.Join(r.Events, ","),
+		Description: r.Description,
+		Active:      true,
+	}
+
+	if _, err := o.Insert(webhook); err != nil {
+		return nil, err
+	}
+
+	return &WebhookResponse{Webhook: webhook, Secret: secret}, nil
+}
+
+func webhookDetail(webhook *Webhook) string {
+	return fmt.Sprintf("webhook %d to %s", webhook.Id, webhook.Url)
+}
+
+func (handler *WebhookHandler) load(o orm.Ormer, request *restful.Request) (*Webhook, error) {
+	id, err := strconv.Atoi(request.PathParameter("webhook-id"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid provided webhook id")
+	}
+
+	webhook := &Webhook{Id: id}
+	if err := o.Read(webhook); err != nil {
+		return nil, fmt.Errorf("unknown webhook")
+	}
+
+	return webhook, nil
+}
+
+func (handler *WebhookHandler) Create(request *restful.Request, response *restful.Response) {
+	r := new(WebhookRequest)
+	if err := request.ReadEntity(r); err != nil {
+		response.WriteErrorString(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	o := orm.NewOrm()
+
+	var created *WebhookResponse
+	var auditErr error
+
+	err := InTransaction(o, func() error {
+		var err error
+		if created, err = NewWebhook(o, r); err != nil {
+			return err
+		}
+
+		auditErr = AppendAudit(o, NewAuditEvent(request, AuditWebhookCreate, nil, 0, webhookDetail(created.Webhook)))
+		return auditErr
+	})
+	if auditErr != nil {
+		response.WriteErrorString(http.StatusInternalServerError, auditErr.Error())
+		return
+	} else if err != nil {
+		response.WriteErrorString(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	response.WriteHeader(http.StatusCreated)
+	response.WriteEntity(created)
+}
+
+func (handler *WebhookHandler) List(request *restful.Request, response *restful.Response) {
+	var webhooks []*Webhook
+
+	if _, err := orm.NewOrm().QueryTable("webhook").OrderBy("Id").All(&webhooks); err != nil {
+		response.WriteErrorString(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.WriteEntity(webhooks)
+}
+
+// Delete deactivates a webhook, keeping its delivery log. Pending deliveries
+// fail on their next attempt.
+func (handler *WebhookHandler) Delete(request *restful.Request, response *restful.Response) {
+	o := orm.NewOrm()
+
+	webhook, err := handler.load(o, request)
+	if err != nil {
+		response.WriteErrorString(http.StatusNotFound, err.Error())
+		return
+	}
+
+	webhook.Active = false
+
+	err = InTransaction(o, func() error {
+		if _, err := o.Update(webhook, "Active"); err != nil {
+			return err
+		}
+		return AppendAudit(o, NewAuditEvent(request, AuditWebhookDelete, nil, 0, webhookDetail(webhook)))
+	})
+	if err != nil {
+		response.WriteErrorString(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.WriteHeader(http.StatusNoContent)
+}
+
+func (handler *WebhookHandler) Deliveries(request *restful.Request, response *restful.Response) {
+	o := orm.NewOrm()
+
+	webhook, err := handler.load(o, request)
+	if err != nil {
+		response.WriteErrorString(http.StatusNotFound, err.Error())
+		return
+	}
+
+	qs := o.QueryTable("webhook_delivery").Filter("Webhook", webhook.Id)
+	if status := request.QueryParameter("status"); status != "" {
+		qs = qs.Filter("Status", status)
+	}
+
+	var deliveries []*WebhookDelivery
+	if _, err := qs.OrderBy("-Id").Limit(100).All(&deliveries); err != nil {
+		response.WriteErrorString(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.WriteEntity(deliveries)
+}
+
+// Ping queues a ping event, to check a receiver and its signature handling.
+func (handler *WebhookHandler) Ping(request *restful.Request, response *restful.Response) {
+	o := orm.NewOrm()
+
+	webhook, err := handler.load(o, request)
+	if err != nil {
+		response.WriteErrorString(http.StatusNotFound, err.Error())
+		return
+	}
+
+	payload, err := json.Marshal(WebhookPayload{Event: EventPing, Time: time.Now()})
+	if err == nil {
+		var delivery *WebhookDelivery
+		delivery, err = enqueue(o, webhook, EventPing, payload)
+		if err == nil {
+			response.WriteHeader(http.StatusAccepted)
+			response.WriteEntity(delivery)
+			return
+		}
+	}
+
+	response.WriteErrorString(http.StatusInternalServerError, err.Error())
+}
+
+func WebhookWebService() *restful.WebService {
+	handler := &WebhookHandler{}
+
+	ws := new(restful.WebService)
+	ws.Path("/1/webhook").
+		Doc("Manage webhook notifications of case events").
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON)
+
+	ws.Route(ws.GET("").Filter(Authorize(ActionAdmin)).To(handler.List).
+		Doc("list webhooks").
+		Operation("listWebhooks").
+		Writes([]Webhook{}))
+
+	ws.Route(ws.POST("").Filter(Authorize(ActionAdmin)).To(handler.Create).
+		Doc("create a webhook and its signing secret").
+		Operation("createWebhook").
+		Reads(WebhookRequest{}).
+		Writes(WebhookResponse{}))
+
+	ws.Route(ws.DELETE("/{webhook-id}").Filter(Authorize(ActionAdmin)).To(handler.Delete).
+		Doc("deactivate a webhook").
+		Operation("deleteWebhook").
+		Param(ws.PathParameter("webhook-id", "webhook identifier").DataType("int")))
+
+	ws.Route(ws.GET("/{webhook-id}/deliveries").Filter(Authorize(ActionAdmin)).To(handler.Deliveries).
+		Doc("list the latest deliveries of a webhook").
+		Operation("listWebhookDeliveries").
+		Param(ws.PathParameter("webhook-id", "webhook identifier").DataType("int")).
+		Param(ws.QueryParameter("status", "pending, delivered or failed")).
+		Writes([]WebhookDelivery{}))
+
+	ws.Route(ws.POST("/{webhook-id}/ping").Filter(Authorize(ActionAdmin)).To(handler.Ping).
+		Doc("send a ping event to a webhook").
+		Operation("pingWebhook").
+		Param(ws.PathParameter("webhook-id", "webhook identifier").DataType("int")).
+		Writes(WebhookDelivery{}))
+
+	return ws
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/astaxie/beego/orm"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDeliveryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		delay    time.Duration
+	}{
+		{1, DeliveryBaseDelay},
+		{2, 2 * DeliveryBaseDelay},
+		{3, 4 * DeliveryBaseDelay},
+		{5, 16 * DeliveryBaseDelay},
+		{7, 64 * DeliveryBaseDelay},
+		{MaxDeliveryAttempts, DeliveryMaxDelay},
+		{100, DeliveryMaxDelay},
+	}
+
+	for _, test := range tests {
+		if got := DeliveryDelay(test.attempts); got != test.delay {
+			t.Errorf("after %d attempts: got %s, want %s", test.attempts, got, test.delay)
+		}
+	}
+}
+
+// receiver is a webhook endpoint answering with the given statuses in turn,
+// the last one repeating. It checks the signature of every request.
+type receiver struct {
+	t        *testing.T
+	secret   string
+	statuses []int
+	mu       sync.Mutex
+	calls    int
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	body, _ := ioutil.ReadAll(request.Body)
+
+	mac := hmac.New(sha256.New, []byte(r.secret))
+	mac.Write(body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	if got := request.Header.Get(WebhookSignatureHeader); !hmac.Equal([]byte(got), []byte(want)) {
+		r.t.Errorf("invalid signature %s, want %s", got, want)
+	}
+
+	status := r.statuses[len(r.statuses)-1]
+	if r.calls < len(r.statuses) {
+		status = r.statuses[r.calls]
+	}
+	r.calls++
+
+	w.WriteHeader(status)
+}
+
+func newTestDelivery(t *testing.T, url string, secret string) *WebhookDelivery {
+	o := openTestDatabase(t)
+
+	webhook := &Webhook{Url: url, Secret: secret, Active: true}
+	if _, err := o.Insert(webhook); err != nil {
+		t.Fatal(err)
+	}
+
+	delivery, err := enqueue(o, webhook, EventPing, []byte(`{"Event":"ping"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return delivery
+}
+
+func cleanDeliveries(t *testing.T) {
+	o := openTestDatabase(t)
+	o.QueryTable("webhook_delivery").Filter("Id__gt", 0).Delete()
+	o.QueryTable("webhook").Filter("Id__gt", 0).Delete()
+}
+
+// attempt runs the dispatcher once with the delivery due, and reloads it.
+func attempt(t *testing.T, client *http.Client, delivery *WebhookDelivery) {
+	o := openTestDatabase(t)
+
+	_, err := o.QueryTable("webhook_delivery").Filter("Id", delivery.Id).
+		Update(orm.Params{"NextAttempt": time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DeliverPending(o, client); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := o.Read(delivery); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeliverRetriesServerErrors(t *testing.T) {
+	defer cleanDeliveries(t)
+
+	r := &receiver{t: t, secret: "s3cret", statuses: []int{500, 503, 200}}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	client := &http.Client{Timeout: DeliveryTimeout}
+
+	delivery := newTestDelivery(t, server.URL, r.secret)
+
+	before := time.Now()
+	attempt(t, client, delivery)
+
+	if delivery.Status != DeliveryPending || delivery.Attempts != 1 || delivery.ResponseCode != 500 {
+		t.Fatalf("after a 500: status %s, %d attempts, code %d", delivery.Status, delivery.Attempts,
+			delivery.ResponseCode)
+	}
+
+	next := delivery.NextAttempt.Sub(before)
+	if next < DeliveryDelay(1)-time.Second || next > DeliveryDelay(1)+5*time.Second {
+		t.Errorf("next attempt in %s, want about %s", next, DeliveryDelay(1))
+	}
+
+	attempt(t, client, delivery)
+	attempt(t, client, delivery)
+
+	if delivery.Status != DeliveryDelivered || delivery.Attempts != 3 || delivery.ResponseCode != 200 {
+		t.Errorf("after a 200: status %s, %d attempts, code %d", delivery.Status, delivery.Attempts,
+			delivery.ResponseCode)
+	}
+
+	if r.calls != 3 {
+		t.Errorf("receiver got %d requests, want 3", r.calls)
+	}
+}
+
+func TestDeliverFailsAfterMaxAttempts(t *testing.T) {
+	defer cleanDeliveries(t)
+
+	r := &receiver{t: t, secret: "s3cret", statuses: []int{502}}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	client := &http.Client{Timeout: DeliveryTimeout}
+
+	delivery := newTestDelivery(t, server.URL, r.secret)
+
+	for i := 0; i < MaxDeliveryAttempts+2; i++ {
+		attempt(t, client, delivery)
+	}
+
+	if delivery.Status != DeliveryFailed || delivery.Attempts != MaxDeliveryAttempts {
+		t.Errorf("status %s after %d attempts, want %s after %d", delivery.Status, delivery.Attempts,
+			DeliveryFailed, MaxDeliveryAttempts)
+	}
+
+	if r.calls != MaxDeliveryAttempts {
+		t.Errorf("receiver got %d requests, want %d", r.calls, MaxDeliveryAttempts)
+	}
+}
+
+func TestClaimDelivery(t *testing.T) {
+	defer cleanDeliveries(t)
+
+	o := openTestDatabase(t)
+	delivery := newTestDelivery(t, "http://127.0.0.1:1/", "s3cret")
+
+	other := &WebhookDelivery{Id: delivery.Id}
+	if err := o.Read(other); err != nil {
+		t.Fatal(err)
+	}
+
+	claimed, err := ClaimDelivery(o, delivery)
+	if err != nil || !claimed {
+		t.Fatalf("first claim: %t, %v", claimed, err)
+	}
+
+	claimed, err = ClaimDelivery(o, other)
+	if err != nil || claimed {
+		t.Errorf("second claim: %t, %v, want the delivery to be taken", claimed, err)
+	}
+
+	// A claimed delivery is not sent again by the dispatcher.
+	if err := DeliverPending(o, http.DefaultClient); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := o.Read(delivery); err != nil {
+		t.Fatal(err)
+	}
+
+	if delivery.Status != DeliverySending || delivery.Attempts != 0 {
+		t.Errorf("claimed delivery was attempted: status %s, %d attempts", delivery.Status, delivery.Attempts)
+	}
+}